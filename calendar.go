@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+	"wallbox_nord_pool/internal/ical"
+	"wallbox_nord_pool/internal/nordpool"
+)
+
+// calendarFeed is the last generated feed, served by the daemon.
+var calendarFeed atomic.Pointer[[]byte]
+
+// writeCalendar turns the cheap windows of the chargers into an iCalendar feed and writes it to the bucket when it changed.
+// The events are stamped with the hour of the prices, the feed changes only when they are refreshed.
+func writeCalendar(svc *s3.S3, awsS3Bucket string, config Config, chargers []ChargerConfig, results []ChargerResult, dryRun bool) {
+	var events []ical.Event
+	for i, charger := range chargers {
+		if results[i].Error != "" {
+			continue
+		}
+		nordPoolConfig := charger.nordPoolConfig(config.NordPool)
+		windows, err := nordpool.GetCheapWindows(svc, awsS3Bucket, time.Now(), nordPoolConfig, results[i].DesiredPrice)
+		if err != nil {
+			slog.Warn("Failed to plan cheap windows", "charger", charger.Name, "error", err)
+			continue
+		}
+		for _, window := range windows {
+			events = append(events, ical.Event{
+				Uid:         fmt.Sprintf("%d-%s@wallbox_nord_pool", window.Start.Unix(), url.PathEscape(charger.Name)),
+				Start:       window.Start,
+				End:         window.End,
+				Summary:     fmt.Sprintf("%s charges", charger.Name),
+				Description: fmt.Sprintf("Average price %.4f %s/kWh", window.Price, nordPoolConfig.CurrencyOrDefault()),
+			})
+		}
+	}
+	feed := ical.Calendar(config.Calendar.CalendarName(), time.Now().Truncate(time.Hour), events)
+	calendarFeed.Store(&feed)
+	if dryRun {
+		return
+	}
+	fileName := config.Calendar.FileName()
+	output, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(awsS3Bucket), Key: aws.String(fileName)})
+	if err == nil {
+		current, _ := io.ReadAll(output.Body)
+		output.Body.Close()
+		if bytes.Equal(current, feed) {
+			return
+		}
+	}
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Body:        bytes.NewReader(feed),
+		Bucket:      aws.String(awsS3Bucket),
+		Key:         aws.String(fileName),
+		ContentType: aws.String("text/calendar; charset=utf-8"),
+	})
+	if err != nil {
+		slog.Warn("Failed to write calendar", "file", fileName, "error", err)
+		return
+	}
+	slog.Info("Wrote calendar", "file", fileName, "events", len(events))
+}
+
+// serveCalendar serves the last generated feed.
+func serveCalendar(w http.ResponseWriter, _ *http.Request) {
+	feed := calendarFeed.Load()
+	if feed == nil {
+		http.Error(w, "calendar not generated yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	_, _ = w.Write(*feed)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"wallbox_nord_pool/internal/backtest"
+	"wallbox_nord_pool/internal/flow"
+	"wallbox_nord_pool/internal/homeassistant"
+	"wallbox_nord_pool/internal/logging"
+	"wallbox_nord_pool/internal/metrics"
+	"wallbox_nord_pool/internal/nordpool"
+	"wallbox_nord_pool/internal/ocpp"
+	"wallbox_nord_pool/internal/validate"
+)
+
+func runCommand(name string, args []string) error {
+	switch name {
+	case "backtest":
+		return backtestCommand(args)
+	case "daemon":
+		return daemonCommand(args)
+	case "validate":
+		return validateCommand(args)
+	case "schema":
+		return schemaCommand(args)
+	case "prices":
+		return pricesCommand(args)
+	case "status":
+		return statusCommand(args)
+	case "pause":
+		return actionCommand(name, flow.ActionPause, args)
+	case "resume":
+		return actionCommand(name, flow.ActionResume, args)
+	case "unlock":
+		return actionCommand(name, flow.ActionUnlock, args)
+	case "plan":
+		return planCommand(args)
+	case "run":
+		return runControllerCommand(args)
+	default:
+		return fmt.Errorf("unknown command %s", name)
+	}
+}
+
+func backtestCommand(args []string) (err error) {
+	flags := flag.NewFlagSet("backtest", flag.ExitOnError)
+	scenarioPath := flags.String("scenario", "", "scenario YAML file")
+	configPath := flags.String("config", "", "local config YAML file, config.yaml from the bucket when empty")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	verbose := flags.Bool("verbose", false, "log the simulation")
+	_ = flags.Parse(args)
+	if *scenarioPath == "" {
+		return errors.New("backtest needs -scenario")
+	}
+	if !*verbose {
+		logging.SetupWith(os.Stderr, slog.LevelWarn, false)
+	}
+	scenarioBytes, err := os.ReadFile(*scenarioPath)
+	if err != nil {
+		return
+	}
+	var scenario backtest.Scenario
+	err = yaml.Unmarshal(scenarioBytes, &scenario)
+	if err != nil {
+		return
+	}
+	awsS3Bucket := os.Getenv("AWS_S3_BUCKET")
+	svc, err := newS3()
+	if err != nil {
+		return
+	}
+	var config Config
+	if *configPath != "" {
+		config, err = readConfigFile(*configPath)
+	} else {
+		err, config = readConfig(svc, awsS3Bucket)
+	}
+	if err != nil {
+		return
+	}
+	reports, err := backtest.Run(scenario, config.NordPool, func(date time.Time) ([]nordpool.Price, error) {
+		return nordpool.GetDayPrices(svc, awsS3Bucket, date, config.NordPool)
+	})
+	if err != nil {
+		return
+	}
+	if *jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(reports)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STRATEGY\tSESSIONS\tENERGY kWh\tCOST\tAVG PRICE\tMISSED DEADLINES\tTOGGLES")
+	for _, report := range reports {
+		var average float64
+		if report.Energy > 0 {
+			average = report.Cost / report.Energy
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.2f\t%.2f\t%.4f\t%d\t%d\n", report.Strategy, report.Sessions, report.Energy, report.Cost, average, report.MissedDeadlines, report.Toggles)
+	}
+	return w.Flush()
+}
+
+// daemonCommand runs the controller every interval and serves the metrics.
+func daemonCommand(args []string) (err error) {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	listen := flags.String("listen", ":9090", "address to serve /metrics, /calendar.ics and OCPP on")
+	interval := flags.Duration("interval", 15*time.Minute, "how often to run the controller")
+	configPath := flags.String("config", "", "local config YAML file, config.yaml from the bucket on every run when empty")
+	overlayPath := flags.String("overlay", "", "config overlay YAML file, config.<hostname>.yaml next to -config when empty")
+	watchInterval := flags.Duration("watch", 2*time.Second, "how often to check the local config files for changes")
+	ocppPath := flags.String("ocpp", "", "path OCPP 1.6J charge points connect to followed by their id, for example /ocpp/, disabled when empty")
+	_ = flags.Parse(args)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	loadConfig := configLoader(readConfig)
+	if *configPath != "" {
+		if *overlayPath == "" {
+			*overlayPath = hostOverlayPath(*configPath)
+		}
+		store, err := newConfigStore(*configPath, *overlayPath)
+		if err != nil {
+			return err
+		}
+		go store.watch(ctx, *watchInterval)
+		loadConfig = store.load
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/calendar.ics", serveCalendar)
+	services := daemonServices{homeAssistant: homeassistant.NewBridge()}
+	defer services.homeAssistant.Close()
+	if *ocppPath != "" {
+		services.centralSystem = ocpp.NewCentralSystem(*ocppPath)
+		mux.Handle(*ocppPath, services.centralSystem)
+	}
+	server := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		slog.Info("Serving metrics", "listen", *listen, "ocpp", *ocppPath)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "error", err)
+			stop()
+		}
+	}()
+	for {
+		runOnce(ctx, loadConfig, services)
+		// runs are aligned to the clock so that they start at price slot boundaries,
+		// Home Assistant commands run the flow right away
+		next := time.Now().Truncate(*interval).Add(*interval)
+		select {
+		case <-ctx.Done():
+			return server.Shutdown(context.Background())
+		case <-time.After(time.Until(next)):
+		case <-services.homeAssistant.Commands():
+		}
+	}
+}
+
+func runOnce(ctx context.Context, loadConfig configLoader, services daemonServices) {
+	summary, err := runWith(ctx, loadConfig, services)
+	if err != nil {
+		slog.Error("Run failed", "error", err)
+		return
+	}
+	slog.Info("Run done", "failed", summary.Failed(), "chargers", len(summary.Chargers))
+}
+
+// validateCommand reports every problem of the config with its YAML path.
+func validateCommand(args []string) (err error) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "", "local config YAML file, config.yaml from the bucket when empty")
+	jsonOutput := flags.Bool("json", false, "print the problems as JSON")
+	_ = flags.Parse(args)
+	var node *yaml.Node
+	if *configPath != "" {
+		node, err = readConfigLayers(*configPath, hostOverlayPath(*configPath))
+	} else {
+		var configBytes []byte
+		configBytes, err = readConfigBytes()
+		if err != nil {
+			return
+		}
+		node, err = configNode(configBytes)
+	}
+	if err != nil {
+		return
+	}
+	_, problems := validateConfig(node)
+	if *jsonOutput {
+		if problems == nil {
+			problems = validate.Problems{}
+		}
+		err = json.NewEncoder(os.Stdout).Encode(problems)
+		if err != nil {
+			return
+		}
+	} else {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("config has %d problems", len(problems))
+	}
+	if !*jsonOutput {
+		fmt.Println("config is valid")
+	}
+	return
+}
+
+func schemaCommand(_ []string) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(validate.Schema(Config{}, "wallbox_nord_pool config"))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+	"wallbox_nord_pool/internal/layers"
+)
+
+// envPrefix names the environment variables overriding config values, for example WNP_NORD_POOL_MAX_PRICE.
+const envPrefix = "WNP"
+
+// configNode parses the config and applies the environment overrides.
+func configNode(configBytes []byte) (node *yaml.Node, err error) {
+	node, err = layers.Parse(configBytes)
+	if err != nil {
+		return
+	}
+	applyEnv(node)
+	return
+}
+
+// readConfigLayers merges the base config file, the overlay file when it exists and the environment overrides.
+func readConfigLayers(basePath string, overlayPath string) (node *yaml.Node, err error) {
+	node, err = layers.ReadFiles(basePath, overlayPath)
+	if err != nil {
+		return
+	}
+	applyEnv(node)
+	return
+}
+
+func applyEnv(node *yaml.Node) {
+	applied := layers.ApplyEnv(node, &Config{}, envPrefix, os.LookupEnv)
+	if len(applied) > 0 {
+		slog.Info("Config overridden from environment", "variables", applied)
+	}
+}
+
+// hostOverlayPath is the overlay of this host, config.<hostname>.yaml next to config.yaml.
+func hostOverlayPath(basePath string) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return ""
+	}
+	extension := filepath.Ext(basePath)
+	return strings.TrimSuffix(basePath, extension) + "." + hostname + extension
+}
+
+// configStore holds the local config of the daemon. A reloaded config is swapped in only when it is valid.
+type configStore struct {
+	basePath    string
+	overlayPath string
+	current     atomic.Pointer[Config]
+}
+
+func newConfigStore(basePath string, overlayPath string) (store *configStore, err error) {
+	store = &configStore{basePath: basePath, overlayPath: overlayPath}
+	err = store.reload()
+	return
+}
+
+func (store *configStore) reload() (err error) {
+	node, err := readConfigLayers(store.basePath, store.overlayPath)
+	if err != nil {
+		return
+	}
+	config, err := loadConfig(node)
+	if err != nil {
+		return
+	}
+	store.current.Store(&config)
+	return
+}
+
+// load is a configLoader returning the current config.
+func (store *configStore) load(_ *s3.S3, _ string) (err error, config Config) {
+	return nil, *store.current.Load()
+}
+
+// watch reloads the config whenever one of its files changes, until ctx is done.
+func (store *configStore) watch(ctx context.Context, interval time.Duration) {
+	layers.NewWatcher(store.basePath, store.overlayPath).Watch(ctx, interval, func() {
+		err := store.reload()
+		if err != nil {
+			slog.Error("Config reload failed, keeping the previous config", "error", err)
+			return
+		}
+		slog.Info("Config reloaded", "config", store.basePath, "overlay", store.overlayPath)
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"slices"
+	"strings"
+	"wallbox_nord_pool/internal/crypt"
+	"wallbox_nord_pool/internal/httpcharger"
+	"wallbox_nord_pool/internal/notify"
+	"wallbox_nord_pool/internal/ocpp"
+	"wallbox_nord_pool/internal/validate"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+const (
+	chargerTypeWallbox = "wallbox"
+	chargerTypeOcpp    = "ocpp"
+	chargerTypeHttp    = "http"
+)
+
+var (
+	errNoCentralSystem    = errors.New("OCPP chargers need the daemon to serve the central system")
+	errUnknownChargerType = errors.New("unknown charger type")
+)
+
+// driverEnv is what the drivers may need to open their chargers for a run.
+type driverEnv struct {
+	config        Config
+	svc           *s3.S3
+	awsS3Bucket   string
+	tokenKey      crypt.KeyProvider
+	notifier      *notify.Notifier
+	centralSystem *ocpp.CentralSystem
+}
+
+// chargerFactory builds a charger of an opened driver.
+type chargerFactory func(charger ChargerConfig) (wallbox.Charger, error)
+
+// driver opens the chargers of one type for a run, once, for example to log in to their cloud.
+type driver struct {
+	open func(env driverEnv) (chargerFactory, error)
+	// validate reports the problems of the config that only matter when the type is used.
+	validate func(config Config, lines validate.Lines) validate.Problems
+}
+
+// drivers are keyed by the type of the charger config.
+var drivers = map[string]driver{
+	chargerTypeWallbox: {open: openWallbox, validate: validateWallbox},
+	chargerTypeOcpp:    {open: openOcpp, validate: validateOcpp},
+	chargerTypeHttp:    {open: openHttp, validate: validateHttp},
+}
+
+// chargerType returns the type of the charger, wallbox when not set.
+func (charger ChargerConfig) chargerType() string {
+	if charger.Type == "" {
+		return chargerTypeWallbox
+	}
+	return strings.ToLower(charger.Type)
+}
+
+// chargerTypes returns the types of the chargers, each once.
+func chargerTypes(chargers []ChargerConfig) (types []string) {
+	seen := map[string]bool{}
+	for _, charger := range chargers {
+		if !seen[charger.chargerType()] {
+			seen[charger.chargerType()] = true
+			types = append(types, charger.chargerType())
+		}
+	}
+	return
+}
+
+// openDrivers opens the drivers of the chargers and returns their factories by type. A driver that fails to
+// open only fails its own chargers, its error is returned by type.
+func openDrivers(env driverEnv, chargers []ChargerConfig) (factories map[string]chargerFactory, errs map[string]error) {
+	factories = map[string]chargerFactory{}
+	errs = map[string]error{}
+	for _, chargerType := range chargerTypes(chargers) {
+		driver, ok := drivers[chargerType]
+		if !ok {
+			errs[chargerType] = fmt.Errorf("%s : %w", chargerType, errUnknownChargerType)
+			continue
+		}
+		factory, err := driver.open(env)
+		if err != nil {
+			errs[chargerType] = err
+			continue
+		}
+		factories[chargerType] = factory
+	}
+	return
+}
+
+// newCharger builds the charger with the factory of its type, or returns the open error of its driver.
+func newCharger(factories map[string]chargerFactory, errs map[string]error, charger ChargerConfig) (wallbox.Charger, error) {
+	if err := errs[charger.chargerType()]; err != nil {
+		return nil, err
+	}
+	return factories[charger.chargerType()](charger)
+}
+
+func validateDrivers(config Config, lines validate.Lines) (problems validate.Problems) {
+	for _, chargerType := range chargerTypes(config.chargers()) {
+		if driver, ok := drivers[chargerType]; ok && driver.validate != nil {
+			problems = append(problems, driver.validate(config, lines)...)
+		}
+	}
+	return
+}
+
+func openWallbox(env driverEnv) (factory chargerFactory, err error) {
+	account, err := wallbox.NewAccount(env.config.Wallbox, env.svc, env.awsS3Bucket, env.tokenKey)
+	if err != nil {
+		env.notifier.Notify(notify.Message{Event: notify.EventTokenRefreshFailed, Text: fmt.Sprintf("Wallbox token refresh failed: %v", err)})
+		return
+	}
+	return func(charger ChargerConfig) (wallbox.Charger, error) {
+		return account.Wallbox(charger.DeviceId), nil
+	}, nil
+}
+
+func validateWallbox(config Config, lines validate.Lines) (problems validate.Problems) {
+	if config.Wallbox.Username == "" {
+		problems = append(problems, lines.Problem("wallbox.username", "is required for wallbox chargers"))
+	}
+	if config.Wallbox.Password == "" {
+		problems = append(problems, lines.Problem("wallbox.password", "is required for wallbox chargers"))
+	}
+	return
+}
+
+func openOcpp(env driverEnv) (factory chargerFactory, err error) {
+	if env.centralSystem == nil {
+		return nil, errNoCentralSystem
+	}
+	return func(charger ChargerConfig) (wallbox.Charger, error) {
+		return env.centralSystem.Charger(charger.DeviceId, env.config.Ocpp), nil
+	}, nil
+}
+
+func validateOcpp(config Config, lines validate.Lines) (problems validate.Problems) {
+	for i, charger := range config.Chargers {
+		if charger.chargerType() == chargerTypeOcpp && !config.Ocpp.HasPassword(charger.DeviceId) {
+			problems = append(problems, lines.Problem(fmt.Sprintf("chargers[%d].device-id", i), "has no OCPP password, set ocpp.password or ocpp.charge-points"))
+		}
+	}
+	return
+}
+
+func openHttp(_ driverEnv) (factory chargerFactory, err error) {
+	return func(charger ChargerConfig) (wallbox.Charger, error) {
+		return httpcharger.NewCharger(charger.Http, charger.DeviceId), nil
+	}, nil
+}
+
+func validateHttp(config Config, lines validate.Lines) (problems validate.Problems) {
+	for i, charger := range config.Chargers {
+		if charger.chargerType() != chargerTypeHttp {
+			continue
+		}
+		path := fmt.Sprintf("chargers[%d].http", i)
+		required := []struct{ field, value string }{
+			{"status.url", charger.Http.Status.Url},
+			{"status-field", charger.Http.StatusField},
+			{"start.url", charger.Http.Start.Url},
+			{"stop.url", charger.Http.Stop.Url},
+		}
+		for _, field := range required {
+			if field.value == "" {
+				problems = append(problems, lines.Problem(path+"."+field.field, "is required for http chargers"))
+			}
+		}
+		for value, status := range charger.Http.Statuses {
+			if !slices.Contains(wallbox.ChargerStatuses, status) {
+				problems = append(problems, lines.Problem(path+".statuses."+value, fmt.Sprintf("%s is not a charger status", status)))
+			}
+		}
+	}
+	return
+}
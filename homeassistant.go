@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log/slog"
+	"time"
+	"wallbox_nord_pool/internal/flow"
+	"wallbox_nord_pool/internal/homeassistant"
+	"wallbox_nord_pool/internal/money"
+	"wallbox_nord_pool/internal/nordpool"
+)
+
+// overrideMaxPrices replaces the max prices of the chargers set from Home Assistant.
+func overrideMaxPrices(bridge *homeassistant.Bridge, chargers []ChargerConfig) {
+	for i := range chargers {
+		if maxPrice := bridge.Override(chargers[i].Name).MaxPrice; maxPrice != nil {
+			price := money.PerKWh(*maxPrice)
+			chargers[i].MaxPrice = &price
+		}
+	}
+}
+
+// overrideModes applies the modes set from Home Assistant to the flow states, the flow then acts on them as usual.
+func overrideModes(bridge *homeassistant.Bridge, chargers []ChargerConfig, states []flow.State, results []ChargerResult) {
+	for i, charger := range chargers {
+		mode := bridge.Override(charger.Name).Mode
+		if results[i].Error != "" || mode == flow.ModeAuto {
+			continue
+		}
+		states[i] = flow.OverrideState(states[i], mode)
+		results[i].Mode = mode
+		if mode == flow.ModeStop {
+			results[i].Free, results[i].Surplus = false, false
+		}
+		slog.Info("Mode overridden", "charger", charger.Name, "mode", mode, "state", states[i])
+	}
+}
+
+// publishHomeAssistant publishes the results with the next cheap window of each charger.
+func publishHomeAssistant(bridge *homeassistant.Bridge, svc *s3.S3, awsS3Bucket string, config Config, chargers []ChargerConfig, results []ChargerResult) {
+	states := make([]homeassistant.ChargerState, len(chargers))
+	for i, charger := range chargers {
+		nordPoolConfig := charger.nordPoolConfig(config.NordPool)
+		maxPrice, _ := nordPoolConfig.Value(nordPoolConfig.MaxPrice)
+		states[i] = homeassistant.ChargerState{
+			Name:         charger.Name,
+			Currency:     string(nordPoolConfig.CurrencyOrDefault()),
+			Status:       results[i].Status,
+			Price:        results[i].Price,
+			DesiredPrice: results[i].DesiredPrice,
+			Mode:         bridge.Override(charger.Name).Mode,
+			MaxPrice:     maxPrice,
+			Action:       results[i].Action,
+			Error:        results[i].Error,
+		}
+		if results[i].Error != "" {
+			continue
+		}
+		windows, err := nordpool.GetCheapWindows(svc, awsS3Bucket, time.Now(), nordPoolConfig, results[i].DesiredPrice)
+		if err != nil {
+			slog.Warn("Failed to plan cheap windows", "charger", charger.Name, "error", err)
+			continue
+		}
+		if len(windows) > 0 {
+			states[i].WindowStart, states[i].WindowEnd = &windows[0].Start, &windows[0].End
+		}
+	}
+	err := bridge.Publish(states)
+	if err != nil {
+		slog.Warn("Failed to publish to Home Assistant", "error", err)
+	}
+}
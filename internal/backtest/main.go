@@ -0,0 +1,202 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"math"
+	"strings"
+	"time"
+	"wallbox_nord_pool/internal/flow"
+	"wallbox_nord_pool/internal/nordpool"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+const slot = 15 * time.Minute
+
+var errInvalidScenario = errors.New("invalid scenario")
+
+type Scenario struct {
+	From         string     `yaml:"from"`
+	To           string     `yaml:"to"`
+	ChargerPower float64    `yaml:"charger-power"`
+	Sessions     []Session  `yaml:"sessions"`
+	Strategies   []Strategy `yaml:"strategies"`
+}
+
+// Session is a car plugged in every day at PlugIn (15:04, local time) needing Energy kWh by the deadline.
+type Session struct {
+	PlugIn   string   `yaml:"plug-in"`
+	Energy   float64  `yaml:"energy"`
+	Weekdays []string `yaml:"weekdays"`
+}
+
+// Strategy overrides the nord-pool config, for example max-price or charge-till-hour-night.
+type Strategy struct {
+	Name     string    `yaml:"name"`
+	NordPool yaml.Node `yaml:"nord-pool"`
+}
+
+type Report struct {
+	Strategy        string  `json:"strategy"`
+	Sessions        int     `json:"sessions"`
+	Energy          float64 `json:"energy"`
+	Cost            float64 `json:"cost"`
+	MissedDeadlines int     `json:"missedDeadlines"`
+	Toggles         int     `json:"toggles"`
+}
+
+// PriceSource returns the zone prices of the day of date.
+type PriceSource func(date time.Time) ([]nordpool.Price, error)
+
+// Run replays prices between scenario dates and simulates every session with every strategy.
+func Run(scenario Scenario, base nordpool.NordPoolConfig, source PriceSource) (reports []Report, err error) {
+	location, err := time.LoadLocation(base.Timezone)
+	if err != nil {
+		return
+	}
+	from, err := time.ParseInLocation(time.DateOnly, scenario.From, location)
+	if err != nil {
+		return
+	}
+	to, err := time.ParseInLocation(time.DateOnly, scenario.To, location)
+	if err != nil {
+		return
+	}
+	if scenario.ChargerPower <= 0 {
+		return nil, fmt.Errorf("charger-power must be positive : %w", errInvalidScenario)
+	}
+	plugIns, err := plugIns(scenario.Sessions, from, to)
+	if err != nil {
+		return
+	}
+	var prices []nordpool.Price
+	// the last sessions may only end on the next day
+	for day := from; !day.After(to.AddDate(0, 0, 1)); day = day.AddDate(0, 0, 1) {
+		var dayPrices []nordpool.Price
+		dayPrices, err = source(day)
+		if err != nil {
+			return
+		}
+		prices = append(prices, dayPrices...)
+	}
+	strategies := scenario.Strategies
+	if len(strategies) == 0 {
+		strategies = []Strategy{{Name: "config"}}
+	}
+	for _, strategy := range strategies {
+		var config nordpool.NordPoolConfig
+		config, err = strategy.config(base)
+		if err != nil {
+			return
+		}
+		report := Report{Strategy: strategy.Name}
+		for _, plugIn := range plugIns {
+			var result sessionResult
+			result, err = simulate(config, prices, plugIn.date, plugIn.energy, scenario.ChargerPower)
+			if err != nil {
+				return
+			}
+			report.Sessions++
+			report.Energy += result.energy
+			report.Cost += result.cost
+			report.Toggles += result.toggles
+			if result.missed {
+				report.MissedDeadlines++
+			}
+		}
+		reports = append(reports, report)
+	}
+	return
+}
+
+func (strategy Strategy) config(base nordpool.NordPoolConfig) (config nordpool.NordPoolConfig, err error) {
+	config = base
+	if !strategy.NordPool.IsZero() {
+		err = strategy.NordPool.Decode(&config)
+	}
+	return
+}
+
+type plugIn struct {
+	date   time.Time
+	energy float64
+}
+
+func plugIns(sessions []Session, from time.Time, to time.Time) (plugIns []plugIn, err error) {
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		for _, session := range sessions {
+			var clock time.Time
+			clock, err = time.Parse("15:04", session.PlugIn)
+			if err != nil {
+				return
+			}
+			if !onWeekday(session.Weekdays, day.Weekday()) {
+				continue
+			}
+			date := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, day.Location())
+			plugIns = append(plugIns, plugIn{date, session.Energy})
+		}
+	}
+	return
+}
+
+func onWeekday(weekdays []string, weekday time.Weekday) bool {
+	if len(weekdays) == 0 {
+		return true
+	}
+	for _, w := range weekdays {
+		if strings.EqualFold(w, weekday.String()[:3]) || strings.EqualFold(w, weekday.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+type sessionResult struct {
+	energy  float64
+	cost    float64
+	toggles int
+	missed  bool
+}
+
+// simulate runs the flow every slot from plug in till the deadline. The car waits paused after plugging in.
+func simulate(config nordpool.NordPoolConfig, prices []nordpool.Price, plugIn time.Time, energy float64, power float64) (result sessionResult, err error) {
+	deadline, err := nordpool.Deadline(config, plugIn)
+	if err != nil {
+		return
+	}
+	maxPrice, err := config.Value(config.MaxPrice)
+	if err != nil {
+		return
+	}
+	var status wallbox.ChargerStatus = wallbox.Paused
+	remaining := energy
+	for date := plugIn.Truncate(slot); date.Before(deadline) && remaining > 0; date = date.Add(slot) {
+		var price, minPrice float64
+		price, err = nordpool.PriceAt(config, prices, date)
+		if err != nil {
+			return
+		}
+		minPrice, err = nordpool.MinPriceTill(config, prices, date)
+		if err != nil {
+			return
+		}
+		switch flow.NewAction(flow.NewFlowsState(price, math.Min(minPrice, maxPrice), status)) {
+		case flow.ActionUnlock, flow.ActionResume:
+			status = wallbox.Charging
+			result.toggles++
+		case flow.ActionPause:
+			status = wallbox.Paused
+			result.toggles++
+		}
+		if status == wallbox.Charging {
+			charged := math.Min(remaining, power*slot.Hours())
+			remaining -= charged
+			result.energy += charged
+			result.cost += charged * price
+		}
+	}
+	result.missed = remaining > 0.001
+	return
+}
@@ -0,0 +1,100 @@
+package backtest
+
+import (
+	"gopkg.in/yaml.v3"
+	"math"
+	"testing"
+	"time"
+	"wallbox_nord_pool/internal/money"
+	"wallbox_nord_pool/internal/nordpool"
+)
+
+func TestRun(t *testing.T) {
+	config := nordpool.NordPoolConfig{
+		MaxPrice:            money.PerKWh(1),
+		ChargeTillHourDay:   18,
+		ChargeTillHourNight: 8,
+		Timezone:            "Europe/Vilnius",
+		TransmissionCost:    nordpool.TransmissionCostConfig{Timezone: "Europe/Vilnius"},
+	}
+	location, _ := time.LoadLocation(config.Timezone)
+	source := func(date time.Time) (prices []nordpool.Price, err error) {
+		if date.Day() != 1 {
+			return
+		}
+		start := time.Date(2023, 8, 1, 0, 0, 0, 0, location)
+		for date := start; date.Before(start.AddDate(0, 0, 2)); date = date.Add(slot) {
+			price := 200.0
+			if date.Day() == 2 && date.Hour() == 2 {
+				price = 50
+			}
+			prices = append(prices, nordpool.Price{Timestamp: date.Unix(), Price: price})
+		}
+		return
+	}
+	var scenario Scenario
+	err := yaml.Unmarshal([]byte(`
+from: 2023-08-01
+to: 2023-08-01
+charger-power: 11
+sessions:
+  - plug-in: "20:00"
+    energy: 11
+strategies:
+  - name: default
+  - name: too-low
+    nord-pool:
+      max-price: 0.03
+`), &scenario)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	reports, err := Run(scenario, config, source)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	tests := []struct {
+		name        string
+		report      Report
+		wantCost    float64
+		wantToggles int
+		wantMissed  int
+	}{
+		{name: "Charges in cheapest hour", report: reports[0], wantCost: 0.55, wantToggles: 1, wantMissed: 0},
+		{name: "Max price below market", report: reports[1], wantCost: 0, wantToggles: 0, wantMissed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if math.Abs(tt.report.Cost-tt.wantCost) > 0.001 {
+				t.Errorf("Got cost %f, wanted %f", tt.report.Cost, tt.wantCost)
+			}
+			if tt.report.Toggles != tt.wantToggles {
+				t.Errorf("Got toggles %d, wanted %d", tt.report.Toggles, tt.wantToggles)
+			}
+			if tt.report.MissedDeadlines != tt.wantMissed {
+				t.Errorf("Got missed deadlines %d, wanted %d", tt.report.MissedDeadlines, tt.wantMissed)
+			}
+		})
+	}
+}
+
+func TestOnWeekday(t *testing.T) {
+	tests := []struct {
+		name     string
+		weekdays []string
+		weekday  time.Weekday
+		want     bool
+	}{
+		{name: "Every day", weekdays: nil, weekday: time.Sunday, want: true},
+		{name: "Short name", weekdays: []string{"mon", "tue"}, weekday: time.Tuesday, want: true},
+		{name: "Full name", weekdays: []string{"Saturday"}, weekday: time.Saturday, want: true},
+		{name: "Other day", weekdays: []string{"mon"}, weekday: time.Friday, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := onWeekday(tt.weekdays, tt.weekday); got != tt.want {
+				t.Errorf("onWeekday() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package charging
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"time"
+)
+
+const (
+	stateFile = "sessions.json"
+	slotSize  = 15 * time.Minute
+	// UnknownPower is the power of a charger without a meter, its session is weighted by time instead of energy.
+	UnknownPower = -1.0
+)
+
+// Session is a charging session with the energy charged at each price, kept between runs.
+type Session struct {
+	Start    time.Time `json:"start"`
+	Slot     time.Time `json:"slot"`
+	LastSeen time.Time `json:"lastSeen"`
+	// Price is the energy cost the charger is set to, per kWh in the nord-pool currency.
+	Price float64 `json:"price"`
+	// Power drawn since LastSeen in watts, 1 when unknown so that the session is weighted by time.
+	Power float64 `json:"power"`
+	// Energy charged in watt seconds and Cost, the sum of price times energy, for the average.
+	Energy float64 `json:"energy"`
+	Cost   float64 `json:"cost"`
+}
+
+// Sessions are the open sessions by charger name.
+type Sessions map[string]*Session
+
+// Update tells whether the energy cost of the charger has to be set.
+type Update struct {
+	SetCost bool
+	Cost    float64
+	Ended   bool
+}
+
+// Average is the energy weighted average price of the session.
+func (session *Session) Average() float64 {
+	if session.Energy <= 0 {
+		return session.Price
+	}
+	return session.Cost / session.Energy
+}
+
+// measure sets the power drawn since the session was last seen, the last known one is kept when unknown.
+func (session *Session) measure(power float64) {
+	if power >= 0 {
+		session.Power = power
+	}
+}
+
+func (session *Session) charge(till time.Time) {
+	seconds := till.Sub(session.LastSeen).Seconds()
+	if seconds > 0 {
+		session.Energy += seconds * session.Power
+		session.Cost += seconds * session.Power * session.Price
+	}
+	session.LastSeen = till
+}
+
+// Update follows the session of the charger. costSet tells that the action of the run already set price as the energy cost.
+// While charging, the energy cost follows the price of every new slot. When the session ends the cost is set to the average.
+// power is drawn by the charger now, in watts or UnknownPower, and taken for the whole time since the previous run.
+// A charger that stopped by itself is counted as charging till the previous run, a paused one till now at its last power.
+func (sessions Sessions) Update(name string, charging bool, paused bool, price float64, power float64, costSet bool, now time.Time) (update Update) {
+	slot := now.Truncate(slotSize)
+	session, open := sessions[name]
+	switch {
+	case charging && !open:
+		sessions[name] = &Session{Start: now, Slot: slot, LastSeen: now, Price: price, Power: 1}
+		sessions[name].measure(power)
+		return Update{SetCost: !costSet, Cost: price}
+	case charging && slot.After(session.Slot):
+		session.measure(power)
+		session.charge(slot)
+		session.Slot = slot
+		session.Price = price
+		session.charge(now)
+		return Update{SetCost: !costSet, Cost: price}
+	case charging:
+		session.measure(power)
+		session.charge(now)
+		return
+	case open:
+		if paused {
+			session.charge(now)
+		}
+		delete(sessions, name)
+		return Update{SetCost: true, Cost: session.Average(), Ended: true}
+	default:
+		return
+	}
+}
+
+func Read(s3svc *s3.S3, awsS3Bucket string) (sessions Sessions, err error) {
+	sessions = Sessions{}
+	input := &s3.GetObjectInput{Bucket: aws.String(awsS3Bucket),
+		Key: aws.String(stateFile),
+	}
+	output, err := s3svc.GetObject(input)
+	if err != nil {
+		// no session was open yet
+		return sessions, nil
+	}
+	defer output.Body.Close()
+	sessionsBytes, err := io.ReadAll(output.Body)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(sessionsBytes, &sessions)
+	if sessions == nil {
+		sessions = Sessions{}
+	}
+	return
+}
+
+func (sessions Sessions) Write(s3svc *s3.S3, awsS3Bucket string) (err error) {
+	sessionsBytes, err := json.Marshal(sessions)
+	if err != nil {
+		return
+	}
+	_, err = s3svc.PutObject(&s3.PutObjectInput{
+		Body:   bytes.NewReader(sessionsBytes),
+		Bucket: &awsS3Bucket,
+		Key:    aws.String(stateFile),
+	})
+	return err
+}
@@ -0,0 +1,73 @@
+package charging
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestUpdate(t *testing.T) {
+	start := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	sessions := Sessions{}
+	steps := []struct {
+		name     string
+		charging bool
+		paused   bool
+		price    float64
+		costSet  bool
+		at       time.Duration
+		want     Update
+	}{
+		{name: "Resume sets the cost itself", charging: true, price: 0.10, costSet: true, at: 0, want: Update{Cost: 0.10}},
+		{name: "Same slot", charging: true, price: 0.10, at: 10 * time.Minute, want: Update{}},
+		{name: "Next slot", charging: true, price: 0.20, at: 20 * time.Minute, want: Update{SetCost: true, Cost: 0.20}},
+		{name: "Paused sets the average", paused: true, price: 0.30, at: 30 * time.Minute, want: Update{SetCost: true, Cost: 0.15, Ended: true}},
+		{name: "Not charging", price: 0.30, at: 45 * time.Minute, want: Update{}},
+		{name: "Already charging", charging: true, price: 0.30, at: 60 * time.Minute, want: Update{SetCost: true, Cost: 0.30}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			got := sessions.Update("garage", step.charging, step.paused, step.price, UnknownPower, step.costSet, start.Add(step.at))
+			if got.SetCost != step.want.SetCost || got.Ended != step.want.Ended || math.Abs(got.Cost-step.want.Cost) > 1e-9 {
+				t.Errorf("Got %+v, wanted %+v", got, step.want)
+			}
+		})
+	}
+}
+
+func TestUpdateStoppedByItself(t *testing.T) {
+	start := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	sessions := Sessions{}
+	sessions.Update("garage", true, false, 0.10, UnknownPower, true, start)
+	sessions.Update("garage", true, false, 0.30, UnknownPower, false, start.Add(15*time.Minute))
+	got := sessions.Update("garage", false, false, 0.50, UnknownPower, false, start.Add(30*time.Minute))
+	if !got.Ended || math.Abs(got.Cost-0.10) > 1e-9 {
+		t.Errorf("Got %+v, wanted the average of the charged time only", got)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("Got sessions %v, wanted none", sessions)
+	}
+}
+
+func TestUpdateUnevenDraw(t *testing.T) {
+	start := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	sessions := Sessions{}
+	sessions.Update("garage", true, false, 0.10, 11000, true, start)
+	sessions.Update("garage", true, false, 0.30, 11000, false, start.Add(15*time.Minute))
+	sessions.Update("garage", true, false, 0.50, 2000, false, start.Add(30*time.Minute))
+	got := sessions.Update("garage", false, false, 0.50, UnknownPower, false, start.Add(45*time.Minute))
+	want := (0.10*11000 + 0.30*2000) / (11000 + 2000)
+	if !got.Ended || math.Abs(got.Cost-want) > 1e-9 {
+		t.Errorf("Got %+v, wanted the energy weighted average %f", got, want)
+	}
+}
+
+func TestUpdateUnknownPowerKeepsLast(t *testing.T) {
+	start := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	sessions := Sessions{}
+	sessions.Update("garage", true, false, 0.10, 4000, true, start)
+	sessions.Update("garage", true, false, 0.30, UnknownPower, false, start.Add(15*time.Minute))
+	if power := sessions["garage"].Power; power != 4000 {
+		t.Errorf("Got power %f, wanted the last known 4000", power)
+	}
+}
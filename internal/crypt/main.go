@@ -0,0 +1,199 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"os"
+	"strings"
+)
+
+const (
+	envelopeVersion = 1
+	keySize         = 32
+)
+
+var (
+	errInvalidKey = errors.New("invalid key")
+)
+
+// Config selects the key encrypting data at rest. KMS wins when both are set, nothing is encrypted when none is.
+type Config struct {
+	KmsKeyId string `yaml:"kms-key-id"`
+	// KeyFile holds a 32 byte AES key, raw or base64 encoded.
+	KeyFile string `yaml:"key-file"`
+}
+
+// KeyProvider hands out data keys for envelope encryption.
+type KeyProvider interface {
+	// DataKey returns a new data key and its encrypted form that is stored with the data.
+	DataKey() (plain []byte, encrypted []byte, err error)
+	// Decrypt returns the data key of its encrypted form.
+	Decrypt(encrypted []byte) (plain []byte, err error)
+}
+
+// Envelope is the stored form: data encrypted with AES-GCM under a data key, the data key encrypted by the provider.
+type Envelope struct {
+	Version    int    `json:"version"`
+	Key        []byte `json:"key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewKeyProvider returns nil when encryption is not configured.
+func NewKeyProvider(config Config, sess *session.Session) (provider KeyProvider, err error) {
+	switch {
+	case config.KmsKeyId != "":
+		return KMS{kms.New(sess), config.KmsKeyId}, nil
+	case config.KeyFile != "":
+		return LoadKeyFile(config.KeyFile)
+	default:
+		return nil, nil
+	}
+}
+
+func Seal(provider KeyProvider, plaintext []byte) (sealed []byte, err error) {
+	plainKey, encryptedKey, err := provider.DataKey()
+	if err != nil {
+		return
+	}
+	nonce, ciphertext, err := encrypt(plainKey, plaintext)
+	if err != nil {
+		return
+	}
+	return json.Marshal(Envelope{Version: envelopeVersion, Key: encryptedKey, Nonce: nonce, Ciphertext: ciphertext})
+}
+
+// Open decrypts sealed data. Data that is not an envelope is returned as it is, with wasSealed false.
+func Open(provider KeyProvider, data []byte) (plaintext []byte, wasSealed bool, err error) {
+	var envelope Envelope
+	if json.Unmarshal(data, &envelope) != nil || envelope.Version == 0 || envelope.Ciphertext == nil {
+		return data, false, nil
+	}
+	if provider == nil {
+		return nil, true, fmt.Errorf("sealed data without a key : %w", errInvalidKey)
+	}
+	plainKey, err := provider.Decrypt(envelope.Key)
+	if err != nil {
+		return nil, true, err
+	}
+	plaintext, err = decrypt(plainKey, envelope.Nonce, envelope.Ciphertext)
+	return plaintext, true, err
+}
+
+func encrypt(key []byte, plaintext []byte) (nonce []byte, ciphertext []byte, err error) {
+	aead, err := newAead(key)
+	if err != nil {
+		return
+	}
+	nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func decrypt(key []byte, nonce []byte, ciphertext []byte) (plaintext []byte, err error) {
+	aead, err := newAead(key)
+	if err != nil {
+		return
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("nonce size %d : %w", len(nonce), errInvalidKey)
+	}
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LocalKey wraps data keys with a key kept in a local file.
+type LocalKey struct {
+	key []byte
+}
+
+func NewLocalKey(key []byte) (LocalKey, error) {
+	if len(key) != keySize {
+		return LocalKey{}, fmt.Errorf("key size %d, want %d : %w", len(key), keySize, errInvalidKey)
+	}
+	return LocalKey{key}, nil
+}
+
+func LoadKeyFile(path string) (localKey LocalKey, err error) {
+	keyBytes, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if len(keyBytes) != keySize {
+		keyBytes, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyBytes)))
+		if err != nil {
+			return localKey, fmt.Errorf("%s : %w", path, errInvalidKey)
+		}
+	}
+	return NewLocalKey(keyBytes)
+}
+
+func (localKey LocalKey) DataKey() (plain []byte, encrypted []byte, err error) {
+	plain = make([]byte, keySize)
+	_, err = rand.Read(plain)
+	if err != nil {
+		return
+	}
+	nonce, ciphertext, err := encrypt(localKey.key, plain)
+	if err != nil {
+		return
+	}
+	return plain, append(nonce, ciphertext...), nil
+}
+
+func (localKey LocalKey) Decrypt(encrypted []byte) (plain []byte, err error) {
+	aead, err := newAead(localKey.key)
+	if err != nil {
+		return
+	}
+	if len(encrypted) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted key too short : %w", errInvalidKey)
+	}
+	return aead.Open(nil, encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():], nil)
+}
+
+// KMS gets data keys from AWS KMS.
+type KMS struct {
+	svc   *kms.KMS
+	keyId string
+}
+
+func (provider KMS) DataKey() (plain []byte, encrypted []byte, err error) {
+	output, err := provider.svc.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(provider.keyId),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return
+	}
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+func (provider KMS) Decrypt(encrypted []byte) (plain []byte, err error) {
+	output, err := provider.svc.Decrypt(&kms.DecryptInput{
+		KeyId:          aws.String(provider.keyId),
+		CiphertextBlob: encrypted,
+	})
+	if err != nil {
+		return
+	}
+	return output.Plaintext, nil
+}
@@ -0,0 +1,93 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(t *testing.T) LocalKey {
+	localKey, err := NewLocalKey(bytes.Repeat([]byte{7}, keySize))
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	return localKey
+}
+
+func TestSealOpen(t *testing.T) {
+	localKey := testKey(t)
+	token := []byte(`{"jwt":"eyJ","ttl":1690840800}`)
+	sealed, err := Seal(localKey, token)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if bytes.Contains(sealed, []byte("eyJ")) {
+		t.Errorf("Sealed data contains the plaintext %s", sealed)
+	}
+	opened, wasSealed, err := Open(localKey, sealed)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if !wasSealed || !bytes.Equal(opened, token) {
+		t.Errorf("Got %s sealed %t, wanted %s", opened, wasSealed, token)
+	}
+}
+
+func TestOpenPlaintext(t *testing.T) {
+	token := []byte(`{"jwt":"eyJ","ttl":1690840800}`)
+	opened, wasSealed, err := Open(testKey(t), token)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if wasSealed || !bytes.Equal(opened, token) {
+		t.Errorf("Got %s sealed %t, wanted plaintext back", opened, wasSealed)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	localKey := testKey(t)
+	sealed, _ := Seal(localKey, []byte("secret"))
+	var envelope Envelope
+	_ = json.Unmarshal(sealed, &envelope)
+	envelope.Ciphertext[0] ^= 1
+	tampered, _ := json.Marshal(envelope)
+	_, _, err := Open(localKey, tampered)
+	if err == nil {
+		t.Errorf("Opened tampered data")
+	}
+	otherKey, _ := NewLocalKey(bytes.Repeat([]byte{8}, keySize))
+	_, _, err = Open(otherKey, sealed)
+	if err == nil {
+		t.Errorf("Opened data with another key")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, keySize)
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{name: "Raw", content: key},
+		{name: "Base64", content: []byte(base64.StdEncoding.EncodeToString(key) + "\n")},
+		{name: "Too short", content: []byte("c2hvcnQ="), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			_ = os.WriteFile(path, tt.content, 0600)
+			localKey, err := LoadKeyFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, wanted error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(localKey.key, key) {
+				t.Errorf("Got key %v", localKey.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package energy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"wallbox_nord_pool/internal/jsonpath"
+	"wallbox_nord_pool/internal/metrics"
+)
+
+const (
+	defaultTimeout    = 5 * time.Second
+	defaultModbusPort = "502"
+)
+
+var (
+	errNoSource = errors.New("no energy source configured")
+	errModbus   = errors.New("modbus error")
+)
+
+var httpClient = metrics.NewHttpClient("energy")
+
+// Source reads the instantaneous grid power in watts, positive when importing and negative when exporting.
+type Source interface {
+	GridPower() (watts float64, err error)
+}
+
+// Config selects one source, HTTP wins when both are set.
+type Config struct {
+	Http   HttpConfig   `yaml:"http"`
+	Modbus ModbusConfig `yaml:"modbus"`
+}
+
+type HttpConfig struct {
+	Url string `yaml:"url"`
+	// Field is the dot separated path of the value in the JSON response, for example data.grid.0.power.
+	Field   string        `yaml:"field"`
+	Timeout time.Duration `yaml:"timeout" validate:"min=0"`
+	// Scale multiplies the value to get watts, Invert negates it when the source reports export as positive.
+	Scale  float64 `yaml:"scale"`
+	Invert bool    `yaml:"invert"`
+}
+
+type ModbusConfig struct {
+	// Address is host or host:port of the inverter or meter.
+	Address  string `yaml:"address"`
+	UnitId   int    `yaml:"unit-id" validate:"min=0,max=255"`
+	Register int    `yaml:"register" validate:"min=0,max=65535"`
+	// Input reads an input register instead of a holding register.
+	Input bool   `yaml:"input"`
+	Type  string `yaml:"type" validate:"oneof=int16 uint16 int32 uint32"`
+	// SwapWords reads 32 bit values low word first.
+	SwapWords bool          `yaml:"swap-words"`
+	Timeout   time.Duration `yaml:"timeout" validate:"min=0"`
+	Scale     float64       `yaml:"scale"`
+	Invert    bool          `yaml:"invert"`
+}
+
+func (config Config) Enabled() bool {
+	return config.Http.Url != "" || config.Modbus.Address != ""
+}
+
+func NewSource(config Config) (Source, error) {
+	switch {
+	case config.Http.Url != "":
+		return Http{config.Http}, nil
+	case config.Modbus.Address != "":
+		return Modbus{config.Modbus}, nil
+	default:
+		return nil, errNoSource
+	}
+}
+
+func toWatts(value float64, scale float64, invert bool) float64 {
+	if scale != 0 {
+		value *= scale
+	}
+	if invert {
+		value = -value
+	}
+	return value
+}
+
+func timeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return defaultTimeout
+}
+
+// Http reads the grid power from a JSON endpoint, for example of a smart meter.
+type Http struct {
+	config HttpConfig
+}
+
+func (source Http) GridPower() (watts float64, err error) {
+	client := *httpClient
+	client.Timeout = timeoutOrDefault(source.config.Timeout)
+	resp, err := client.Get(source.config.Url)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("%s %s : %s", source.config.Url, resp.Status, body)
+	}
+	var document any
+	err = json.NewDecoder(resp.Body).Decode(&document)
+	if err != nil {
+		return
+	}
+	value, err := jsonpath.Float(document, source.config.Field)
+	if err != nil {
+		return
+	}
+	return toWatts(value, source.config.Scale, source.config.Invert), nil
+}
+
+// Modbus reads the grid power from a register of a local inverter or meter over Modbus TCP.
+type Modbus struct {
+	config ModbusConfig
+}
+
+func (source Modbus) GridPower() (watts float64, err error) {
+	count := 1
+	if strings.HasSuffix(source.config.Type, "32") {
+		count = 2
+	}
+	registers, err := source.readRegisters(count)
+	if err != nil {
+		return
+	}
+	value := decodeRegisters(registers, source.config.Type, source.config.SwapWords)
+	return toWatts(value, source.config.Scale, source.config.Invert), nil
+}
+
+func (source Modbus) readRegisters(count int) (registers []uint16, err error) {
+	address := source.config.Address
+	if _, _, splitErr := net.SplitHostPort(address); splitErr != nil {
+		address = net.JoinHostPort(address, defaultModbusPort)
+	}
+	timeout := timeoutOrDefault(source.config.Timeout)
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return
+	}
+	function := byte(3)
+	if source.config.Input {
+		function = 4
+	}
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint16(request[0:], 1)
+	binary.BigEndian.PutUint16(request[4:], 6)
+	request[6] = byte(source.config.UnitId)
+	request[7] = function
+	binary.BigEndian.PutUint16(request[8:], uint16(source.config.Register))
+	binary.BigEndian.PutUint16(request[10:], uint16(count))
+	_, err = conn.Write(request)
+	if err != nil {
+		return
+	}
+	header := make([]byte, 7)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length < 2 {
+		return nil, fmt.Errorf("response length %d : %w", length, errModbus)
+	}
+	pdu := make([]byte, length-1)
+	_, err = io.ReadFull(conn, pdu)
+	if err != nil {
+		return
+	}
+	if pdu[0] != function {
+		return nil, fmt.Errorf("exception code %d : %w", pdu[len(pdu)-1], errModbus)
+	}
+	if len(pdu) < 2+2*count || int(pdu[1]) != 2*count {
+		return nil, fmt.Errorf("short response : %w", errModbus)
+	}
+	registers = make([]uint16, count)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(pdu[2+2*i:])
+	}
+	return
+}
+
+func decodeRegisters(registers []uint16, valueType string, swapWords bool) float64 {
+	if len(registers) == 1 {
+		if valueType == "uint16" {
+			return float64(registers[0])
+		}
+		return float64(int16(registers[0]))
+	}
+	high, low := registers[0], registers[1]
+	if swapWords {
+		high, low = low, high
+	}
+	value := uint32(high)<<16 | uint32(low)
+	if valueType == "uint32" {
+		return float64(value)
+	}
+	return float64(int32(value))
+}
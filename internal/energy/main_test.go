@@ -0,0 +1,101 @@
+package energy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpGridPower(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"meters":[{"power":"-1.5"},{"power":2}]}}`))
+	}))
+	defer server.Close()
+	tests := []struct {
+		name    string
+		config  HttpConfig
+		want    float64
+		wantErr bool
+	}{
+		{name: "String in kW", config: HttpConfig{Url: server.URL, Field: "data.meters.0.power", Scale: 1000}, want: -1500},
+		{name: "Export positive", config: HttpConfig{Url: server.URL, Field: "data.meters.1.power", Invert: true}, want: -2},
+		{name: "Missing field", config: HttpConfig{Url: server.URL, Field: "data.meters.2.power"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Http{tt.config}.GridPower()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, wanted error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Got power %f, wanted %f", got, tt.want)
+			}
+		})
+	}
+}
+
+// serveModbus answers one request with the registers, or with an exception when registers is nil.
+func serveModbus(t *testing.T, registers []uint16) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request := make([]byte, 12)
+		if _, err = io.ReadFull(conn, request); err != nil {
+			return
+		}
+		pdu := []byte{request[7], byte(2 * len(registers))}
+		for _, register := range registers {
+			pdu = binary.BigEndian.AppendUint16(pdu, register)
+		}
+		if registers == nil {
+			pdu = []byte{request[7] | 0x80, 2}
+		}
+		response := append(append([]byte{}, request[:4]...), 0, 0, request[6])
+		binary.BigEndian.PutUint16(response[4:], uint16(len(pdu)+1))
+		_, _ = conn.Write(append(response, pdu...))
+	}()
+	return listener.Addr().String()
+}
+
+func TestModbusGridPower(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    ModbusConfig
+		registers []uint16
+		want      float64
+		wantErr   bool
+	}{
+		{name: "int16", config: ModbusConfig{Type: "int16"}, registers: []uint16{0xfc18}, want: -1000},
+		{name: "uint16 scaled", config: ModbusConfig{Type: "uint16", Scale: 10}, registers: []uint16{150}, want: 1500},
+		{name: "int32", config: ModbusConfig{Type: "int32", Input: true}, registers: []uint16{0xffff, 0xfc18}, want: -1000},
+		{name: "int32 swapped", config: ModbusConfig{Type: "int32", SwapWords: true, Invert: true}, registers: []uint16{0x0bb8, 0}, want: -3000},
+		{name: "Exception", config: ModbusConfig{Type: "int16"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Address = serveModbus(t, tt.registers)
+			got, err := Modbus{tt.config}.GridPower()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, wanted error %t", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errModbus) {
+				t.Errorf("Got error %v, wanted %v", err, errModbus)
+			}
+			if got != tt.want {
+				t.Errorf("Got power %f, wanted %f", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package flow
 
 import (
-	"log"
+	"log/slog"
 	"wallbox_nord_pool/internal/nordpool"
 	"wallbox_nord_pool/internal/wallbox"
 )
@@ -16,34 +16,112 @@ var (
 	PausedPriceGood        = State{wallbox.Paused, nordpool.PriceGood}
 	ScheduledPriceGood     = State{wallbox.Scheduled, nordpool.PriceGood}
 	ChargingPriceTooBig    = State{wallbox.Charging, nordpool.PriceTooBig}
+	ChargingPriceGood      = State{wallbox.Charging, nordpool.PriceGood}
+	LockedPriceFree        = State{wallbox.Locked, nordpool.PriceFree}
+	LockedWaitingPriceFree = State{wallbox.LockedWaiting, nordpool.PriceFree}
+	PausedPriceFree        = State{wallbox.Paused, nordpool.PriceFree}
+	ScheduledPriceFree     = State{wallbox.Scheduled, nordpool.PriceFree}
+	ChargingPriceFree      = State{wallbox.Charging, nordpool.PriceFree}
 )
 
-type ActionFunc func(wb wallbox.Wallbox, energyCost float64) (err error)
+type Action string
+
+const (
+	ActionNone   Action = "none"
+	ActionUnlock Action = "unlock"
+	ActionResume Action = "resume"
+	ActionPause  Action = "pause"
+)
+
+type ActionFunc func(wb wallbox.Charger, energyCost float64, logger *slog.Logger) (err error)
 
 func DoFlow(state State) (action ActionFunc) {
+	return DoAction(NewAction(state))
+}
+
+// NewAction tells which action the flow takes for the state without performing it.
+func NewAction(state State) Action {
 	switch state {
-	case LockedWaitingPriceGood:
+	case LockedWaitingPriceGood, LockedPriceFree, LockedWaitingPriceFree:
+		return ActionUnlock
+	case PausedPriceGood, PausedPriceFree:
+		return ActionResume
+	case ScheduledPriceGood, ScheduledPriceFree:
+		return ActionResume
+	case ChargingPriceTooBig:
+		return ActionPause
+	default:
+		return ActionNone
+	}
+}
+
+func DoAction(action Action) ActionFunc {
+	switch action {
+	case ActionUnlock:
 		return actionUnlock
-	case PausedPriceGood:
+	case ActionResume:
 		return actionResume
-	case ScheduledPriceGood:
-		return actionResume
-	case ChargingPriceTooBig:
+	case ActionPause:
 		return actionPause
 	default:
 		return actionEmpty
 	}
 }
 
+// WillCharge tells whether the charger draws current once the flow for the state is done: the action starts
+// the charge, or the charger already charges and is left alone.
+func WillCharge(state State) bool {
+	switch NewAction(state) {
+	case ActionUnlock, ActionResume:
+		return true
+	case ActionNone:
+		return state.ChargerStatus == wallbox.Charging
+	default:
+		return false
+	}
+}
+
+// FreeState is the state when the pool price is at or below the floor, whatever the price otherwise is.
+func FreeState(chargerStatus wallbox.ChargerStatus) State {
+	return State{chargerStatus, nordpool.PriceFree}
+}
+
+// Mode overrides the price, for example from Home Assistant.
+type Mode string
+
+const (
+	// ModeAuto follows the price.
+	ModeAuto Mode = "auto"
+	// ModeCharge charges whatever the price.
+	ModeCharge Mode = "charge"
+	// ModeStop doesn't charge whatever the price.
+	ModeStop Mode = "stop"
+)
+
+var Modes = []Mode{ModeAuto, ModeCharge, ModeStop}
+
+// OverrideState replaces the price status of the state as the mode asks, so that DoFlow acts on it as on any price.
+// A free price is kept when charging, it charges at max current.
+func OverrideState(state State, mode Mode) State {
+	switch {
+	case mode == ModeCharge && state.PriceStatus != nordpool.PriceFree:
+		return State{state.ChargerStatus, nordpool.PriceGood}
+	case mode == ModeStop:
+		return State{state.ChargerStatus, nordpool.PriceTooBig}
+	default:
+		return state
+	}
+}
+
 func NewFlowsState(price float64, desiredPrice float64, chargerStatus wallbox.ChargerStatus) (flowState State) {
-	if price-desiredPrice >= 0.01 {
+	if !nordpool.IsGood(price, desiredPrice) {
 		return State{chargerStatus, nordpool.PriceTooBig}
 	} else {
 		return State{chargerStatus, nordpool.PriceGood}
 	}
 }
-func actionUnlock(wb wallbox.Wallbox, energyCost float64) (err error) {
-	log.Printf("Setting energy cost to %f and performing action unlock", energyCost)
+func actionUnlock(wb wallbox.Charger, energyCost float64, logger *slog.Logger) (err error) {
+	logger.Info("Setting energy cost and performing action", "action", ActionUnlock, "price", energyCost)
 	err = wb.SetEnergyCost(energyCost)
 	if err != nil {
 		return
@@ -51,8 +129,8 @@ func actionUnlock(wb wallbox.Wallbox, energyCost float64) (err error) {
 	return wb.Unlock()
 }
 
-func actionResume(wb wallbox.Wallbox, energyCost float64) (err error) {
-	log.Printf("Setting energy cost to %f and performing action resume", energyCost)
+func actionResume(wb wallbox.Charger, energyCost float64, logger *slog.Logger) (err error) {
+	logger.Info("Setting energy cost and performing action", "action", ActionResume, "price", energyCost)
 	err = wb.SetEnergyCost(energyCost)
 	if err != nil {
 		return
@@ -60,12 +138,12 @@ func actionResume(wb wallbox.Wallbox, energyCost float64) (err error) {
 	return wb.ResumeCharging()
 }
 
-func actionPause(wb wallbox.Wallbox, _ float64) (err error) {
-	log.Println("Performing action pause")
+func actionPause(wb wallbox.Charger, _ float64, logger *slog.Logger) (err error) {
+	logger.Info("Performing action", "action", ActionPause)
 	return wb.PauseCharging()
 }
 
-func actionEmpty(_ wallbox.Wallbox, _ float64) (err error) {
-	log.Println("Performing empty action")
+func actionEmpty(_ wallbox.Charger, _ float64, logger *slog.Logger) (err error) {
+	logger.Info("Performing action", "action", ActionNone)
 	return err
 }
@@ -20,6 +20,10 @@ func TestDoFlow(t *testing.T) {
 		{name: "ChargingPriceTooBig", state: ChargingPriceTooBig, wantAction: "wallbox_nord_pool/internal/flow.actionPause"},
 		{name: "WaitingForCarPriceGood", state: State{wallbox.WaitingForCar, nordpool.PriceGood}, wantAction: "wallbox_nord_pool/internal/flow.actionEmpty"},
 		{name: "WaitingPriceGood", state: State{wallbox.Waiting, nordpool.PriceGood}, wantAction: "wallbox_nord_pool/internal/flow.actionEmpty"},
+		{name: "LockedPriceFree", state: LockedPriceFree, wantAction: "wallbox_nord_pool/internal/flow.actionUnlock"},
+		{name: "LockedWaitingPriceFree", state: LockedWaitingPriceFree, wantAction: "wallbox_nord_pool/internal/flow.actionUnlock"},
+		{name: "PausedPriceFree", state: PausedPriceFree, wantAction: "wallbox_nord_pool/internal/flow.actionResume"},
+		{name: "ChargingPriceFree", state: ChargingPriceFree, wantAction: "wallbox_nord_pool/internal/flow.actionEmpty"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -52,3 +56,116 @@ func TestNewFlowsState(t *testing.T) {
 		})
 	}
 }
+
+func TestOverrideState(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      State
+		mode       Mode
+		wantAction Action
+	}{
+		{name: "AutoFollowsPrice", state: ChargingPriceTooBig, mode: ModeAuto, wantAction: ActionPause},
+		{name: "ChargeResumes", state: State{wallbox.Paused, nordpool.PriceTooBig}, mode: ModeCharge, wantAction: ActionResume},
+		{name: "ChargeKeepsCharging", state: ChargingPriceTooBig, mode: ModeCharge, wantAction: ActionNone},
+		{name: "ChargeKeepsFree", state: PausedPriceFree, mode: ModeCharge, wantAction: ActionResume},
+		{name: "StopPauses", state: ChargingPriceGood, mode: ModeStop, wantAction: ActionPause},
+		{name: "StopKeepsLocked", state: LockedWaitingPriceFree, mode: ModeStop, wantAction: ActionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := OverrideState(tt.state, tt.mode)
+			if tt.mode == ModeCharge && tt.state.PriceStatus == nordpool.PriceFree && state != tt.state {
+				t.Errorf("Got state %v, wanted the free state kept", state)
+			}
+			gotAction := NewAction(state)
+			if gotAction != tt.wantAction {
+				t.Errorf("Got action %s, wanted %s", gotAction, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestWillCharge(t *testing.T) {
+	tests := []struct {
+		name  string
+		state State
+		want  bool
+	}{
+		{name: "LockedWaitingPriceGood", state: LockedWaitingPriceGood, want: true},
+		{name: "ChargingPriceGood", state: ChargingPriceGood, want: true},
+		{name: "ChargingPriceTooBig", state: ChargingPriceTooBig, want: false},
+		{name: "WaitingForCarPriceGood", state: State{wallbox.WaitingForCar, nordpool.PriceGood}, want: false},
+		{name: "ChargingPriceFree", state: ChargingPriceFree, want: true},
+		{name: "LockedPriceFree", state: LockedPriceFree, want: true},
+		{name: "LockedPriceGood", state: State{wallbox.Locked, nordpool.PriceGood}, want: false},
+		{name: "ScheduledPriceFree", state: ScheduledPriceFree, want: true},
+		{name: "PausedPriceTooBig", state: State{wallbox.Paused, nordpool.PriceTooBig}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WillCharge(tt.state); got != tt.want {
+				t.Errorf("WillCharge() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAction(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      State
+		wantAction Action
+	}{
+		{name: "LockedWaitingPriceGood", state: LockedWaitingPriceGood, wantAction: ActionUnlock},
+		{name: "ScheduledPriceGood", state: ScheduledPriceGood, wantAction: ActionResume},
+		{name: "ChargingPriceTooBig", state: ChargingPriceTooBig, wantAction: ActionPause},
+		{name: "ChargingPriceGood", state: ChargingPriceGood, wantAction: ActionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewAction(tt.state); got != tt.wantAction {
+				t.Errorf("NewAction() = %s, want %s", got, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestShareSurplus(t *testing.T) {
+	config := SurplusConfig{Volts: 230, Phases: 1}
+	pausedPriceTooBig := State{wallbox.Paused, nordpool.PriceTooBig}
+	waitingForCar := State{wallbox.WaitingForCar, nordpool.PriceGood}
+	tests := []struct {
+		name      string
+		gridPower float64
+		chargers  []SurplusCharger
+		want      []int
+	}{
+		{name: "Exporting resumes paused", gridPower: -2300,
+			chargers: []SurplusCharger{{State: pausedPriceTooBig}}, want: []int{10}},
+		{name: "Charging adds its own draw", gridPower: -460,
+			chargers: []SurplusCharger{{State: ChargingPriceTooBig, Current: 8}}, want: []int{10}},
+		{name: "Importing lowers current", gridPower: 460,
+			chargers: []SurplusCharger{{State: ChargingPriceTooBig, Current: 10}}, want: []int{8}},
+		{name: "Below minimum falls back", gridPower: -1000,
+			chargers: []SurplusCharger{{State: pausedPriceTooBig}}, want: []int{0}},
+		{name: "Capped at max current", gridPower: -23000,
+			chargers: []SurplusCharger{{State: pausedPriceTooBig, MaxCurrent: 16}}, want: []int{16}},
+		{name: "Shared in order", gridPower: -4600,
+			chargers: []SurplusCharger{{State: waitingForCar}, {State: pausedPriceTooBig, MaxCurrent: 12}, {State: LockedWaitingPriceGood}},
+			want:     []int{0, 12, 8}},
+		{name: "Measured draw below the set current", gridPower: -1380,
+			chargers: []SurplusCharger{{State: ChargingPriceTooBig, Current: 16, Power: 1380, Metered: true, MaxCurrent: 16}, {State: pausedPriceTooBig}},
+			want:     []int{12, 0}},
+		{name: "Unmetered ramps by the export", gridPower: -1380,
+			chargers: []SurplusCharger{{State: ChargingPriceTooBig, Current: 10, MaxCurrent: 16}, {State: pausedPriceTooBig}},
+			want:     []int{16, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := config.ShareSurplus(tt.gridPower, tt.chargers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ShareSurplus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
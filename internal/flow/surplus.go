@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"math"
+	"wallbox_nord_pool/internal/nordpool"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+const (
+	defaultVolts      = 230
+	defaultPhases     = 1
+	defaultMinCurrent = 6
+	defaultMaxCurrent = 32
+)
+
+// SurplusConfig converts between current and power when soaking up solar surplus.
+type SurplusConfig struct {
+	Volts  float64 `yaml:"volts" validate:"min=0"`
+	Phases int     `yaml:"phases" validate:"min=0,max=3"`
+}
+
+// SurplusCharger is a charger that may take surplus, with the current it is set to and the power it draws now.
+type SurplusCharger struct {
+	State   State
+	Current int
+	// Power is the measured draw, watts, when Metered. Chargers without a meter ramp from Current by the export only,
+	// as the car may draw less than it is set to.
+	Power      float64
+	Metered    bool
+	MinCurrent int
+	MaxCurrent int
+}
+
+// ShareSurplus shares the exported power between the chargers in order. gridPower is in watts, negative when exporting,
+// and includes what the chargers draw now. A charger gets surplus only when it can charge with at least its minimum current.
+// The surplus current of every charger is returned, 0 when it gets none and falls back to the price flow.
+func (config SurplusConfig) ShareSurplus(gridPower float64, chargers []SurplusCharger) (currents []int) {
+	currents = make([]int, len(chargers))
+	wattsPerAmp := config.volts() * float64(config.phases())
+	available := -gridPower
+	for _, charger := range chargers {
+		if charger.State.ChargerStatus == wallbox.Charging && charger.Metered {
+			available += charger.Power
+		}
+	}
+	for i, charger := range chargers {
+		if !WillCharge(SurplusState(charger.State)) {
+			continue
+		}
+		// what an unmetered charger draws is not in available, it keeps its current and ramps by the export
+		var base int
+		if charger.State.ChargerStatus == wallbox.Charging && !charger.Metered {
+			base = charger.Current
+		}
+		minCurrent, maxCurrent := charger.Limits()
+		current := min(base+int(math.Floor(available/wattsPerAmp)), maxCurrent)
+		if current < minCurrent {
+			continue
+		}
+		currents[i] = current
+		available -= float64(current-base) * wattsPerAmp
+	}
+	return
+}
+
+// Limits returns the min and max current of the charger, defaults when not set.
+func (charger SurplusCharger) Limits() (minCurrent int, maxCurrent int) {
+	return CurrentLimits(charger.MinCurrent, charger.MaxCurrent)
+}
+
+// CurrentLimits applies the defaults to the configured min and max current.
+func CurrentLimits(minCurrent int, maxCurrent int) (int, int) {
+	if minCurrent <= 0 {
+		minCurrent = defaultMinCurrent
+	}
+	if maxCurrent <= 0 {
+		maxCurrent = defaultMaxCurrent
+	}
+	return minCurrent, maxCurrent
+}
+
+// SurplusState is the state of a charger that takes surplus, which is good whatever the price, unless it is free.
+func SurplusState(state State) State {
+	if state.PriceStatus == nordpool.PriceFree {
+		return state
+	}
+	return State{state.ChargerStatus, nordpool.PriceGood}
+}
+
+func (config SurplusConfig) volts() float64 {
+	if config.Volts > 0 {
+		return config.Volts
+	}
+	return defaultVolts
+}
+
+func (config SurplusConfig) phases() int {
+	if config.Phases > 0 {
+		return config.Phases
+	}
+	return defaultPhases
+}
@@ -0,0 +1,66 @@
+package homeassistant
+
+import (
+	"wallbox_nord_pool/internal/flow"
+)
+
+// Entity is a discovery payload and the topic it is published to.
+type Entity struct {
+	Topic   string
+	Payload map[string]any
+}
+
+// Discovery returns the entities of a charger: sensors of the state, a select of the mode and a number of the max price.
+// The commands are retained, so that the overrides survive restarts of the daemon. Prices are in currency per kWh.
+func Discovery(config Config, name string, currency string) (entities []Entity) {
+	prefix, id := config.topicPrefix(), ObjectId(name)
+	if currency == "" {
+		currency = "EUR"
+	}
+	device := map[string]any{
+		"identifiers":  []string{prefix + "_" + id},
+		"name":         name,
+		"manufacturer": "wallbox_nord_pool",
+	}
+	entity := func(component string, key string, entityName string, valueTemplate string, extra map[string]any) {
+		payload := map[string]any{
+			"name":               entityName,
+			"unique_id":          prefix + "_" + id + "_" + key,
+			"object_id":          prefix + "_" + id + "_" + key,
+			"state_topic":        prefix + "/" + id + "/state",
+			"value_template":     valueTemplate,
+			"availability_topic": prefix + "/status",
+			"device":             device,
+		}
+		for k, v := range extra {
+			payload[k] = v
+		}
+		entities = append(entities, Entity{Topic: config.discoveryPrefix() + "/" + component + "/" + prefix + "_" + id + "/" + key + "/config", Payload: payload})
+	}
+	price := map[string]any{"unit_of_measurement": currency + "/kWh", "state_class": "measurement"}
+	entity("sensor", "status", "Status", "{{ value_json.status }}", nil)
+	entity("sensor", "action", "Action", "{{ value_json.action }}", nil)
+	entity("sensor", "price", "Price", "{{ value_json.price }}", price)
+	entity("sensor", "desired_price", "Desired price", "{{ value_json.desired_price }}", price)
+	entity("sensor", "window_start", "Next cheap window start", "{{ value_json.window_start or None }}", map[string]any{"device_class": "timestamp"})
+	entity("sensor", "window_end", "Next cheap window end", "{{ value_json.window_end or None }}", map[string]any{"device_class": "timestamp"})
+	modes := make([]string, len(flow.Modes))
+	for i, mode := range flow.Modes {
+		modes[i] = string(mode)
+	}
+	entity("select", "mode", "Mode", "{{ value_json.mode }}", map[string]any{
+		"command_topic": prefix + "/" + id + "/mode/set",
+		"options":       modes,
+		"retain":        true,
+	})
+	entity("number", "max_price", "Max price", "{{ value_json.max_price }}", map[string]any{
+		"command_topic":       prefix + "/" + id + "/max-price/set",
+		"min":                 0,
+		"max":                 1,
+		"step":                0.001,
+		"mode":                "box",
+		"unit_of_measurement": currency + "/kWh",
+		"retain":              true,
+	})
+	return
+}
@@ -0,0 +1,270 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wallbox_nord_pool/internal/flow"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+const (
+	defaultTopicPrefix     = "wallbox_nord_pool"
+	defaultDiscoveryPrefix = "homeassistant"
+	timeout                = 10 * time.Second
+	online                 = "online"
+	offline                = "offline"
+)
+
+var errTimeout = errors.New("mqtt timed out")
+
+var unsafeId = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// Config connects the daemon to the MQTT broker of Home Assistant.
+type Config struct {
+	// Broker is the url of the broker, for example tcp://homeassistant.local:1883.
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username" secret:"true"`
+	Password string `yaml:"password" secret:"true"`
+	ClientId string `yaml:"client-id"`
+	// TopicPrefix prefixes the state and command topics, wallbox_nord_pool when empty.
+	TopicPrefix string `yaml:"topic-prefix"`
+	// DiscoveryPrefix is the discovery prefix of Home Assistant, homeassistant when empty.
+	DiscoveryPrefix string `yaml:"discovery-prefix"`
+}
+
+func (config Config) Enabled() bool {
+	return config.Broker != ""
+}
+
+func (config Config) topicPrefix() string {
+	if config.TopicPrefix == "" {
+		return defaultTopicPrefix
+	}
+	return config.TopicPrefix
+}
+
+func (config Config) discoveryPrefix() string {
+	if config.DiscoveryPrefix == "" {
+		return defaultDiscoveryPrefix
+	}
+	return config.DiscoveryPrefix
+}
+
+// Override is what Home Assistant asked of a charger.
+type Override struct {
+	Mode flow.Mode
+	// MaxPrice replaces the max price of the charger when set.
+	MaxPrice *float64
+}
+
+// ChargerState is published to the state topic of the charger after each run, prices are in Currency per kWh.
+type ChargerState struct {
+	Name         string                `json:"-"`
+	Currency     string                `json:"-"`
+	Status       wallbox.ChargerStatus `json:"status"`
+	Price        float64               `json:"price"`
+	DesiredPrice float64               `json:"desired_price"`
+	// WindowStart and WindowEnd are the next cheap window, null when there is none till the deadline.
+	WindowStart *time.Time  `json:"window_start"`
+	WindowEnd   *time.Time  `json:"window_end"`
+	Mode        flow.Mode   `json:"mode"`
+	MaxPrice    float64     `json:"max_price"`
+	Action      flow.Action `json:"action"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// Bridge publishes the charger states with discovery payloads and takes the overrides from the command topics.
+type Bridge struct {
+	mu         sync.Mutex
+	config     Config
+	client     mqtt.Client
+	overrides  map[string]Override
+	discovered map[string]bool
+	commands   chan struct{}
+}
+
+func NewBridge() *Bridge {
+	return &Bridge{overrides: map[string]Override{}, discovered: map[string]bool{}, commands: make(chan struct{}, 1)}
+}
+
+// ObjectId turns a charger name into the id used in its topics.
+func ObjectId(name string) string {
+	return strings.Trim(unsafeId.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// Update connects to the broker of the config, reconnecting when the config changed, and disconnects when it is disabled.
+func (bridge *Bridge) Update(config Config) (err error) {
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	if bridge.client != nil && reflect.DeepEqual(bridge.config, config) {
+		return
+	}
+	bridge.disconnect()
+	bridge.config = config
+	if !config.Enabled() {
+		return
+	}
+	prefix := config.topicPrefix()
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientId).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetConnectTimeout(timeout).
+		SetAutoReconnect(true).
+		SetWill(prefix+"/status", offline, 1, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			bridge.onConnect(client, prefix)
+		})
+	client := mqtt.NewClient(options)
+	err = wait(client.Connect())
+	if err != nil {
+		return fmt.Errorf("%s : %w", config.Broker, err)
+	}
+	bridge.client = client
+	slog.Info("Connected to Home Assistant", "broker", config.Broker)
+	return
+}
+
+// onConnect subscribes to the commands and marks the discovery payloads to be published again, also on reconnects.
+func (bridge *Bridge) onConnect(client mqtt.Client, prefix string) {
+	bridge.mu.Lock()
+	bridge.discovered = map[string]bool{}
+	bridge.mu.Unlock()
+	err := wait(client.SubscribeMultiple(map[string]byte{prefix + "/+/mode/set": 1, prefix + "/+/max-price/set": 1}, bridge.onCommand))
+	if err != nil {
+		slog.Warn("Failed to subscribe to Home Assistant commands", "error", err)
+	}
+	client.Publish(prefix+"/status", 1, true, online)
+}
+
+func (bridge *Bridge) onCommand(_ mqtt.Client, message mqtt.Message) {
+	parts := strings.Split(message.Topic(), "/")
+	if len(parts) < 3 {
+		return
+	}
+	id, command, payload := parts[len(parts)-3], parts[len(parts)-2], strings.TrimSpace(string(message.Payload()))
+	bridge.mu.Lock()
+	override := bridge.overrides[id]
+	switch command {
+	case "mode":
+		mode := flow.Mode(strings.ToLower(payload))
+		if !slices.Contains(flow.Modes, mode) {
+			bridge.mu.Unlock()
+			slog.Warn("Unknown Home Assistant mode", "charger", id, "mode", payload)
+			return
+		}
+		override.Mode = mode
+	case "max-price":
+		override.MaxPrice = nil
+		if payload != "" && payload != "None" {
+			maxPrice, err := strconv.ParseFloat(payload, 64)
+			if err != nil || maxPrice < 0 {
+				bridge.mu.Unlock()
+				slog.Warn("Invalid Home Assistant max price", "charger", id, "maxPrice", payload)
+				return
+			}
+			override.MaxPrice = &maxPrice
+		}
+	}
+	bridge.overrides[id] = override
+	bridge.mu.Unlock()
+	slog.Info("Home Assistant override", "charger", id, "command", command, "value", payload)
+	select {
+	case bridge.commands <- struct{}{}:
+	default:
+	}
+}
+
+// Commands receives when an override changed, so that the daemon runs the flow right away.
+func (bridge *Bridge) Commands() <-chan struct{} {
+	return bridge.commands
+}
+
+// Override returns the override of the charger, auto mode when there is none.
+func (bridge *Bridge) Override(name string) (override Override) {
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	override = bridge.overrides[ObjectId(name)]
+	if override.Mode == "" {
+		override.Mode = flow.ModeAuto
+	}
+	return
+}
+
+// Publish publishes the states, with the discovery payloads of chargers not yet discovered on this connection.
+func (bridge *Bridge) Publish(states []ChargerState) (err error) {
+	bridge.mu.Lock()
+	client, config := bridge.client, bridge.config
+	bridge.mu.Unlock()
+	if client == nil {
+		return
+	}
+	prefix := config.topicPrefix()
+	for _, state := range states {
+		id := ObjectId(state.Name)
+		bridge.mu.Lock()
+		discovered := bridge.discovered[id]
+		bridge.mu.Unlock()
+		if !discovered {
+			discoveryErr := bridge.publishDiscovery(client, config, state)
+			if discoveryErr != nil {
+				err = errors.Join(err, discoveryErr)
+			} else {
+				bridge.mu.Lock()
+				bridge.discovered[id] = true
+				bridge.mu.Unlock()
+			}
+		}
+		payload, marshalErr := json.Marshal(state)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		err = errors.Join(err, wait(client.Publish(prefix+"/"+id+"/state", 1, true, payload)))
+	}
+	return
+}
+
+func (bridge *Bridge) publishDiscovery(client mqtt.Client, config Config, state ChargerState) (err error) {
+	for _, entity := range Discovery(config, state.Name, state.Currency) {
+		payload, marshalErr := json.Marshal(entity.Payload)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		err = errors.Join(err, wait(client.Publish(entity.Topic, 1, true, payload)))
+	}
+	return
+}
+
+// Close marks the daemon offline and disconnects.
+func (bridge *Bridge) Close() {
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	bridge.disconnect()
+}
+
+func (bridge *Bridge) disconnect() {
+	if bridge.client == nil {
+		return
+	}
+	_ = wait(bridge.client.Publish(bridge.config.topicPrefix()+"/status", 1, true, offline))
+	bridge.client.Disconnect(250)
+	bridge.client = nil
+}
+
+func wait(token mqtt.Token) error {
+	if !token.WaitTimeout(timeout) {
+		return errTimeout
+	}
+	return token.Error()
+}
@@ -0,0 +1,271 @@
+package homeassistant
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wallbox_nord_pool/internal/flow"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+// broker is an MQTT 3.1.1 broker with just enough of the protocol for the bridge: QoS 0 delivery,
+// retained messages and wildcard subscriptions.
+type broker struct {
+	mu       sync.Mutex
+	retained map[string][]byte
+	sessions map[net.Conn][]string
+}
+
+func startBroker(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	b := &broker{retained: map[string][]byte{}, sessions: map[net.Conn][]string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return "tcp://" + listener.Addr().String()
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return
+		}
+		body := make([]byte, length)
+		if _, err = io.ReadFull(reader, body); err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			b.mu.Lock()
+			b.sessions[conn] = nil
+			b.mu.Unlock()
+			b.write(conn, 0x20, []byte{0, 0})
+		case 3: // PUBLISH
+			qos, retain := (header>>1)&3, header&1 == 1
+			topicLength := int(binary.BigEndian.Uint16(body))
+			topic, rest := string(body[2:2+topicLength]), body[2+topicLength:]
+			if qos > 0 {
+				b.write(conn, 0x40, rest[:2])
+				rest = rest[2:]
+			}
+			b.publish(topic, rest, retain)
+		case 8: // SUBSCRIBE
+			id, rest := body[:2], body[2:]
+			var filters []string
+			granted := append([]byte{}, id...)
+			for len(rest) > 0 {
+				filterLength := int(binary.BigEndian.Uint16(rest))
+				filters = append(filters, string(rest[2:2+filterLength]))
+				rest = rest[3+filterLength:]
+				granted = append(granted, 0)
+			}
+			b.mu.Lock()
+			b.sessions[conn] = append(b.sessions[conn], filters...)
+			var retained [][]byte
+			for topic, payload := range b.retained {
+				for _, filter := range filters {
+					if matches(filter, topic) {
+						retained = append(retained, publishBody(topic, payload))
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+			b.write(conn, 0x90, granted)
+			for _, message := range retained {
+				b.write(conn, 0x31, message)
+			}
+		case 12: // PINGREQ
+			b.write(conn, 0xd0, nil)
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func (b *broker) publish(topic string, payload []byte, retain bool) {
+	b.mu.Lock()
+	if retain {
+		b.retained[topic] = payload
+	}
+	var conns []net.Conn
+	for conn, filters := range b.sessions {
+		for _, filter := range filters {
+			if matches(filter, topic) {
+				conns = append(conns, conn)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, conn := range conns {
+		b.write(conn, 0x30, publishBody(topic, payload))
+	}
+}
+
+func (b *broker) write(conn net.Conn, header byte, body []byte) {
+	packet := append([]byte{header}, binary.AppendUvarint(nil, uint64(len(body)))...)
+	_, _ = conn.Write(append(packet, body...))
+}
+
+func publishBody(topic string, payload []byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+	return append(append(body, topic...), payload...)
+}
+
+func matches(filter string, topic string) bool {
+	filterParts, topicParts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, part := range filterParts {
+		if part == "#" {
+			return true
+		}
+		if i >= len(topicParts) || part != "+" && part != topicParts[i] {
+			return false
+		}
+	}
+	return len(filterParts) == len(topicParts)
+}
+
+// homeAssistant connects as Home Assistant and collects the messages of the bridge.
+func homeAssistant(t *testing.T, broker string) (client mqtt.Client, messages func(topic string) []byte) {
+	var mu sync.Mutex
+	received := map[string][]byte{}
+	client = mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID("homeassistant"))
+	if err := wait(client.Connect()); err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	err := wait(client.Subscribe("#", 0, func(_ mqtt.Client, message mqtt.Message) {
+		mu.Lock()
+		received[message.Topic()] = message.Payload()
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	return client, func(topic string) []byte {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			mu.Lock()
+			payload, ok := received[topic]
+			mu.Unlock()
+			if ok {
+				return payload
+			}
+		}
+		t.Fatalf("Got no message on %s", topic)
+		return nil
+	}
+}
+
+func TestBridge(t *testing.T) {
+	url := startBroker(t)
+	ha, message := homeAssistant(t, url)
+	bridge := NewBridge()
+	err := bridge.Update(Config{Broker: url, ClientId: "bridge"})
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if got := string(message("wallbox_nord_pool/status")); got != online {
+		t.Errorf("Got status %s, wanted %s", got, online)
+	}
+	start, end := time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	err = bridge.Publish([]ChargerState{{Name: "Garage 1", Status: wallbox.Paused, Price: 0.12, DesiredPrice: 0.08, WindowStart: &start, WindowEnd: &end, Mode: flow.ModeAuto, MaxPrice: 0.1}})
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	var state map[string]any
+	_ = json.Unmarshal(message("wallbox_nord_pool/garage_1/state"), &state)
+	if state["status"] != "Paused" || state["window_start"] != "2024-01-02T01:00:00Z" {
+		t.Errorf("Got state %v", state)
+	}
+	var mode map[string]any
+	_ = json.Unmarshal(message("homeassistant/select/wallbox_nord_pool_garage_1/mode/config"), &mode)
+	if mode["command_topic"] != "wallbox_nord_pool/garage_1/mode/set" || mode["state_topic"] != "wallbox_nord_pool/garage_1/state" {
+		t.Errorf("Got mode discovery %v", mode)
+	}
+
+	ha.Publish("wallbox_nord_pool/garage_1/mode/set", 1, true, "charge")
+	ha.Publish("wallbox_nord_pool/garage_1/max-price/set", 1, true, "0.05")
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		select {
+		case <-bridge.Commands():
+		case <-time.After(100 * time.Millisecond):
+		}
+		if override := bridge.Override("Garage 1"); override.MaxPrice != nil {
+			break
+		}
+	}
+	override := bridge.Override("Garage 1")
+	if override.Mode != flow.ModeCharge || override.MaxPrice == nil || *override.MaxPrice != 0.05 {
+		t.Errorf("Got override %+v", override)
+	}
+	if other := bridge.Override("Garage 2"); other.Mode != flow.ModeAuto || other.MaxPrice != nil {
+		t.Errorf("Got override %+v for a charger without commands", other)
+	}
+	bridge.Close()
+	if got := string(message("wallbox_nord_pool/status")); got != offline {
+		t.Errorf("Got status %s, wanted %s", got, offline)
+	}
+
+	restarted := NewBridge()
+	err = restarted.Update(Config{Broker: url, ClientId: "bridge"})
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	defer restarted.Close()
+	select {
+	case <-restarted.Commands():
+	case <-time.After(5 * time.Second):
+	}
+	time.Sleep(50 * time.Millisecond)
+	if override := restarted.Override("Garage 1"); override.Mode != flow.ModeCharge {
+		t.Errorf("Got override %+v after restart, wanted the retained mode", override)
+	}
+}
+
+func TestObjectId(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Garage 1", want: "garage_1"},
+		{name: "office/2", want: "office_2"},
+		{name: "12345", want: "12345"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ObjectId(tt.name); got != tt.want {
+				t.Errorf("Got id %s, wanted %s", got, tt.want)
+			}
+		})
+	}
+}
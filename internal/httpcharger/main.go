@@ -0,0 +1,221 @@
+package httpcharger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+	"wallbox_nord_pool/internal/jsonpath"
+	"wallbox_nord_pool/internal/metrics"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+const defaultTimeout = 10 * time.Second
+
+var (
+	errNoStatus = errors.New("status url is not configured")
+	errNoPower  = errors.New("power-field is not configured")
+)
+
+var httpClient = metrics.NewHttpClient("http_charger")
+
+// Config drives a DIY charger, for example an OpenEVSE, through its HTTP/JSON API.
+// Urls and bodies are templates of Params, for example http://evse.local/override or {"charge_current": {{.Current}}}.
+type Config struct {
+	// Headers values may be secret references, for example env:EVSE_AUTHORIZATION.
+	Headers map[string]string `yaml:"headers" secret:"true"`
+	Timeout time.Duration     `yaml:"timeout" validate:"min=0"`
+	Status  Request           `yaml:"status"`
+	// StatusField is the dot separated path of the status in the status response, for example state.
+	StatusField string `yaml:"status-field"`
+	// Statuses maps the values of StatusField onto charger statuses, for example 1: Ready, 2: LockedWaiting, 3: Charging.
+	// Unmapped values are Unknown.
+	Statuses map[string]wallbox.ChargerStatus `yaml:"statuses"`
+	// CurrentField is the dot separated path of the current limit in the status response, amperes.
+	CurrentField string `yaml:"current-field"`
+	// PowerField is the dot separated path of the power drawn in the status response, watts.
+	PowerField string `yaml:"power-field"`
+	// Start and Stop resume and pause charging.
+	Start      Request `yaml:"start"`
+	Stop       Request `yaml:"stop"`
+	SetCurrent Request `yaml:"set-current"`
+	// Unlock authorizes a waiting car, Start is used when not set.
+	Unlock Request `yaml:"unlock"`
+}
+
+type Request struct {
+	// Method defaults to GET without a body and POST with one.
+	Method string `yaml:"method" validate:"oneof=GET POST PUT PATCH"`
+	Url    string `yaml:"url"`
+	Body   string `yaml:"body"`
+}
+
+func (request Request) Enabled() bool {
+	return request.Url != ""
+}
+
+// Params are the values the request templates are executed with.
+type Params struct {
+	DeviceId string
+	Current  int
+}
+
+// Charger implements wallbox.Charger with the configured requests.
+type Charger struct {
+	config   Config
+	deviceId string
+}
+
+func NewCharger(config Config, deviceId string) Charger {
+	return Charger{config: config, deviceId: deviceId}
+}
+
+func (charger Charger) GetStatus() (status wallbox.ChargerStatus, err error) {
+	document, err := charger.status()
+	if err != nil {
+		return
+	}
+	value, err := jsonpath.String(document, charger.config.StatusField)
+	if err != nil {
+		return
+	}
+	status, ok := charger.config.Statuses[value]
+	if !ok {
+		return wallbox.Unknown, nil
+	}
+	return
+}
+
+// GetMaxCurrent returns the current limit, 0 when CurrentField is not set.
+func (charger Charger) GetMaxCurrent() (current int, err error) {
+	if charger.config.CurrentField == "" {
+		return
+	}
+	document, err := charger.status()
+	if err != nil {
+		return
+	}
+	value, err := jsonpath.Float(document, charger.config.CurrentField)
+	return int(value), err
+}
+
+// GetChargingPower returns the power drawn, an error when PowerField is not set.
+func (charger Charger) GetChargingPower() (power float64, err error) {
+	if charger.config.PowerField == "" {
+		return 0, errNoPower
+	}
+	document, err := charger.status()
+	if err != nil {
+		return
+	}
+	return jsonpath.Float(document, charger.config.PowerField)
+}
+
+// SetMaxCurrent does nothing when SetCurrent is not set.
+func (charger Charger) SetMaxCurrent(current int) (err error) {
+	if !charger.config.SetCurrent.Enabled() {
+		return
+	}
+	_, err = charger.do(charger.config.SetCurrent, Params{DeviceId: charger.deviceId, Current: current})
+	return
+}
+
+// SetEnergyCost does nothing, the chargers don't know the price.
+func (charger Charger) SetEnergyCost(_ float64) error {
+	return nil
+}
+
+func (charger Charger) Unlock() (err error) {
+	request := charger.config.Unlock
+	if !request.Enabled() {
+		request = charger.config.Start
+	}
+	_, err = charger.do(request, Params{DeviceId: charger.deviceId})
+	return
+}
+
+func (charger Charger) PauseCharging() (err error) {
+	_, err = charger.do(charger.config.Stop, Params{DeviceId: charger.deviceId})
+	return
+}
+
+func (charger Charger) ResumeCharging() (err error) {
+	_, err = charger.do(charger.config.Start, Params{DeviceId: charger.deviceId})
+	return
+}
+
+func (charger Charger) status() (document any, err error) {
+	if !charger.config.Status.Enabled() {
+		return nil, errNoStatus
+	}
+	body, err := charger.do(charger.config.Status, Params{DeviceId: charger.deviceId})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(body, &document)
+	return
+}
+
+func (charger Charger) do(request Request, params Params) (responseBody []byte, err error) {
+	url, err := execute(request.Url, params)
+	if err != nil {
+		return
+	}
+	body, err := execute(request.Body, params)
+	if err != nil {
+		return
+	}
+	method := strings.ToUpper(request.Method)
+	if method == "" {
+		method = "GET"
+		if body != "" {
+			method = "POST"
+		}
+	}
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range charger.config.Headers {
+		req.Header.Set(key, value)
+	}
+	client := *httpClient
+	client.Timeout = defaultTimeout
+	if charger.config.Timeout > 0 {
+		client.Timeout = charger.config.Timeout
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	responseBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s : %s", method, url, resp.Status)
+	}
+	return
+}
+
+func execute(text string, params Params) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("request").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, params)
+	return out.String(), err
+}
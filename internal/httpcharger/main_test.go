@@ -0,0 +1,115 @@
+package httpcharger
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+// openEvse serves the parts of the OpenEVSE API the config below uses.
+func openEvse(t *testing.T, state string) (config Config, requests *[]string) {
+	var mu sync.Mutex
+	requests = &[]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		*requests = append(*requests, r.Method+" "+r.URL.Path+" "+string(body))
+		mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"state":` + state + `,"pilot":16,"power":1380,"status":"active"}`))
+	}))
+	t.Cleanup(server.Close)
+	config = Config{
+		Headers:      map[string]string{"Authorization": "Bearer token"},
+		Status:       Request{Url: server.URL + "/status"},
+		StatusField:  "state",
+		Statuses:     map[string]wallbox.ChargerStatus{"1": wallbox.Ready, "2": wallbox.LockedWaiting, "3": wallbox.Charging, "254": wallbox.Paused},
+		CurrentField: "pilot",
+		Start:        Request{Url: server.URL + "/override", Body: `{"state":"active"}`},
+		Stop:         Request{Url: server.URL + "/override", Body: `{"state":"disabled"}`},
+		SetCurrent:   Request{Method: "patch", Url: server.URL + "/override", Body: `{"charge_current":{{.Current}}}`},
+	}
+	return
+}
+
+func TestGetStatus(t *testing.T) {
+	tests := []struct {
+		state string
+		want  wallbox.ChargerStatus
+	}{
+		{state: "1", want: wallbox.Ready},
+		{state: "3", want: wallbox.Charging},
+		{state: "254", want: wallbox.Paused},
+		{state: "255", want: wallbox.Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			config, _ := openEvse(t, tt.state)
+			got, err := NewCharger(config, "evse").GetStatus()
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("Got status %s, wanted %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetChargingPower(t *testing.T) {
+	config, _ := openEvse(t, "3")
+	_, err := NewCharger(config, "evse").GetChargingPower()
+	if !errors.Is(err, errNoPower) {
+		t.Errorf("Got Error %v, wanted %v", err, errNoPower)
+	}
+	config.PowerField = "power"
+	power, err := NewCharger(config, "evse").GetChargingPower()
+	if err != nil || power != 1380 {
+		t.Errorf("Got power %f, error %v, wanted 1380", power, err)
+	}
+}
+
+func TestActions(t *testing.T) {
+	config, requests := openEvse(t, "2")
+	charger := NewCharger(config, "evse")
+	current, err := charger.GetMaxCurrent()
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if current != 16 {
+		t.Errorf("Got current %d, wanted 16", current)
+	}
+	actions := []func() error{charger.Unlock, charger.PauseCharging, charger.ResumeCharging, func() error { return charger.SetMaxCurrent(10) }}
+	for _, action := range actions {
+		if err := action(); err != nil {
+			t.Fatalf("Got Error %s", err)
+		}
+	}
+	want := []string{
+		"GET /status ",
+		`POST /override {"state":"active"}`,
+		`POST /override {"state":"disabled"}`,
+		`POST /override {"state":"active"}`,
+		`PATCH /override {"charge_current":10}`,
+	}
+	if len(*requests) != len(want) {
+		t.Fatalf("Got requests %q, wanted %q", *requests, want)
+	}
+	for i := range want {
+		if (*requests)[i] != want[i] {
+			t.Errorf("Got request %q, wanted %q", (*requests)[i], want[i])
+		}
+	}
+	config.Headers = nil
+	_, err = NewCharger(config, "evse").GetStatus()
+	if err == nil {
+		t.Errorf("Got no error for an unauthorized request")
+	}
+}
@@ -0,0 +1,101 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	productId  = "-//wallbox_nord_pool//cheap windows//EN"
+	timeFormat = "20060102T150405Z"
+	// maxLineOctets is where RFC 5545 folds content lines.
+	maxLineOctets = 75
+)
+
+// Config exports the cheap windows of the chargers as an iCalendar feed.
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// File is the bucket object the feed is written to, calendar.ics when empty. The daemon also serves it on /calendar.ics.
+	File string `yaml:"file"`
+	// Name is shown by calendar apps, Car charging when empty.
+	Name string `yaml:"name"`
+}
+
+func (config Config) FileName() string {
+	if config.File == "" {
+		return "calendar.ics"
+	}
+	return config.File
+}
+
+func (config Config) CalendarName() string {
+	if config.Name == "" {
+		return "Car charging"
+	}
+	return config.Name
+}
+
+type Event struct {
+	// Uid stays the same when the event is regenerated, so that calendar apps update it instead of adding another.
+	Uid         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+}
+
+// Calendar renders the events as an RFC 5545 VCALENDAR. Stamp is the DTSTAMP of all events,
+// so that the same events render the same.
+func Calendar(name string, stamp time.Time, events []Event) []byte {
+	var out bytes.Buffer
+	line := func(name string, value string) {
+		writeLine(&out, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", productId)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	line("X-WR-CALNAME", escape(name))
+	for _, event := range events {
+		line("BEGIN", "VEVENT")
+		line("UID", escape(event.Uid))
+		line("DTSTAMP", stamp.UTC().Format(timeFormat))
+		line("DTSTART", event.Start.UTC().Format(timeFormat))
+		line("DTEND", event.End.UTC().Format(timeFormat))
+		line("SUMMARY", escape(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION", escape(event.Description))
+		}
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return out.Bytes()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escape(text string) string {
+	return escaper.Replace(text)
+}
+
+// writeLine folds the line at 75 octets, without splitting UTF-8 characters, and ends it with CRLF.
+func writeLine(out *bytes.Buffer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		out.WriteString(line[:cut])
+		out.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of continuation lines counts
+		limit = maxLineOctets - 1
+	}
+	out.WriteString(line)
+	out.WriteString("\r\n")
+}
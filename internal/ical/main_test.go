@@ -0,0 +1,53 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar(t *testing.T) {
+	stamp := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	location, _ := time.LoadLocation("Europe/Vilnius")
+	events := []Event{{
+		Uid:         "garage-1690844400@wallbox_nord_pool",
+		Start:       time.Date(2023, 8, 1, 2, 0, 0, 0, location),
+		End:         time.Date(2023, 8, 1, 5, 30, 0, 0, location),
+		Summary:     "Garage charges",
+		Description: "Average price 0.0812 EUR/kWh, windows; are free",
+	}}
+	got := string(Calendar("Car charging", stamp, events))
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"BEGIN:VEVENT\r\nUID:garage-1690844400@wallbox_nord_pool\r\nDTSTAMP:20230801T000000Z\r\n",
+		"DTSTART:20230731T230000Z\r\nDTEND:20230801T023000Z\r\n",
+		`DESCRIPTION:Average price 0.0812 EUR/kWh\, windows\; are free` + "\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Got calendar %q, wanted it to contain %q", got, want)
+		}
+	}
+	if got != string(Calendar("Car charging", stamp, events)) {
+		t.Errorf("Got a different calendar for the same events")
+	}
+}
+
+func TestFolding(t *testing.T) {
+	summary := strings.Repeat("ą", 60)
+	got := string(Calendar("Car charging", time.Now(), []Event{{Uid: "1", Summary: summary}}))
+	var unfolded strings.Builder
+	for i, line := range strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n") {
+		if len(line) > maxLineOctets {
+			t.Errorf("Got line %d of %d octets", i, len(line))
+		}
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+		} else {
+			unfolded.WriteString("\n" + line)
+		}
+	}
+	if !strings.Contains(unfolded.String(), "\nSUMMARY:"+summary+"\n") {
+		t.Errorf("Got unfolded %q, wanted the summary back", unfolded.String())
+	}
+}
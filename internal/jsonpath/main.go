@@ -0,0 +1,67 @@
+package jsonpath
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrNotFound = errors.New("field not found")
+
+// Lookup returns the value at the dot separated path of a decoded JSON document, for example data.meters.0.power.
+func Lookup(document any, path string) (value any, err error) {
+	value = document
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]any:
+			value = node[key]
+		case []any:
+			i, convErr := strconv.Atoi(key)
+			if convErr != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("%s : %w", path, ErrNotFound)
+			}
+			value = node[i]
+		default:
+			value = nil
+		}
+	}
+	if value == nil {
+		return nil, fmt.Errorf("%s : %w", path, ErrNotFound)
+	}
+	return
+}
+
+// Float returns the number at path, numbers in strings are parsed.
+func Float(document any, path string) (value float64, err error) {
+	found, err := Lookup(document, path)
+	if err != nil {
+		return
+	}
+	switch node := found.(type) {
+	case float64:
+		return node, nil
+	case string:
+		return strconv.ParseFloat(node, 64)
+	default:
+		return 0, fmt.Errorf("%s : %w", path, ErrNotFound)
+	}
+}
+
+// String returns the scalar at path as text, numbers without trailing zeros.
+func String(document any, path string) (value string, err error) {
+	found, err := Lookup(document, path)
+	if err != nil {
+		return
+	}
+	switch node := found.(type) {
+	case string:
+		return node, nil
+	case float64:
+		return strconv.FormatFloat(node, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(node), nil
+	default:
+		return "", fmt.Errorf("%s : %w", path, ErrNotFound)
+	}
+}
@@ -0,0 +1,52 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	var document any
+	_ = json.Unmarshal([]byte(`{"data":{"meters":[{"power":"-1.5"},{"power":2}]},"state":3,"active":true}`), &document)
+	tests := []struct {
+		path      string
+		want      string
+		wantFloat float64
+		wantErr   bool
+	}{
+		{path: "data.meters.0.power", want: "-1.5", wantFloat: -1.5},
+		{path: "data.meters.1.power", want: "2", wantFloat: 2},
+		{path: "state", want: "3", wantFloat: 3},
+		{path: "data.meters.2.power", wantErr: true},
+		{path: "data.missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := String(document, tt.path)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("Got error %v, wanted %s", err, ErrNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("Got value %s, wanted %s", got, tt.want)
+			}
+			gotFloat, err := Float(document, tt.path)
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if gotFloat != tt.wantFloat {
+				t.Errorf("Got value %f, wanted %f", gotFloat, tt.wantFloat)
+			}
+		})
+	}
+	got, err := String(document, "active")
+	if err != nil || got != "true" {
+		t.Errorf("Got value %s, error %v, wanted true", got, err)
+	}
+}
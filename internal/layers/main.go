@@ -0,0 +1,218 @@
+package layers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	errEmptyDocument = errors.New("empty document")
+	nodeType         = reflect.TypeOf(yaml.Node{})
+	unmarshalerType  = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+// Parse returns the top node of a YAML document.
+func Parse(in []byte) (node *yaml.Node, err error) {
+	var document yaml.Node
+	err = yaml.Unmarshal(in, &document)
+	if err != nil {
+		return
+	}
+	if len(document.Content) == 0 {
+		return nil, errEmptyDocument
+	}
+	return document.Content[0], nil
+}
+
+// ReadFiles merges the YAML files in order, later files overriding earlier ones.
+// The first file is required, the others are skipped when they don't exist.
+func ReadFiles(base string, overlays ...string) (node *yaml.Node, err error) {
+	node, err = readFile(base)
+	if err != nil {
+		return
+	}
+	for _, path := range overlays {
+		overlay, err := readFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		node = Merge(node, overlay)
+	}
+	return
+}
+
+func readFile(path string) (node *yaml.Node, err error) {
+	in, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	node, err = Parse(in)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", path, err)
+	}
+	return
+}
+
+// Merge merges mappings key by key. Anything else in overlay, lists included, replaces base.
+func Merge(base *yaml.Node, overlay *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || overlay.Kind != yaml.MappingNode {
+		return overlay
+	}
+	merged := *base
+	merged.Content = append([]*yaml.Node{}, base.Content...)
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		if j := find(&merged, key.Value); j >= 0 {
+			merged.Content[j+1] = Merge(merged.Content[j+1], value)
+		} else {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+	return &merged
+}
+
+func find(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// ApplyEnv sets the scalar fields of out's type from environment variables named after their YAML path,
+// for example nord-pool.max-price is PREFIX_NORD_POOL_MAX_PRICE. Lists and maps can't be overridden.
+// It returns the names of the variables that were applied.
+func ApplyEnv(node *yaml.Node, out any, prefix string, lookup func(string) (string, bool)) (applied []string) {
+	for _, path := range scalarPaths(reflect.TypeOf(out), nil) {
+		name := EnvName(prefix, path)
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		set(node, path, value)
+		applied = append(applied, name)
+	}
+	return
+}
+
+// EnvName is the environment variable overriding the YAML path.
+func EnvName(prefix string, path []string) string {
+	name := strings.ToUpper(strings.Join(append([]string{prefix}, path...), "_"))
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+func scalarPaths(t reflect.Type, path []string) (paths [][]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == nodeType {
+			return
+		}
+		// structs that unmarshal themselves, like prices with units, are written as scalars
+		if reflect.PointerTo(t).Implements(unmarshalerType) {
+			return [][]string{path}
+		}
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := yamlName(t.Field(i))
+			if !ok {
+				continue
+			}
+			fieldPath := append(append([]string{}, path...), name)
+			paths = append(paths, scalarPaths(t.Field(i).Type, fieldPath)...)
+		}
+	case reflect.Slice, reflect.Map, reflect.Interface:
+	default:
+		paths = append(paths, path)
+	}
+	return
+}
+
+func yamlName(field reflect.StructField) (name string, ok bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ = strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, true
+}
+
+func set(node *yaml.Node, path []string, value string) {
+	for _, key := range path {
+		if node.Kind != yaml.MappingNode {
+			*node = yaml.Node{Kind: yaml.MappingNode}
+		}
+		i := find(node, key)
+		if i < 0 {
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &yaml.Node{Kind: yaml.MappingNode})
+			i = len(node.Content) - 2
+		}
+		node = node.Content[i+1]
+	}
+	*node = yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
+
+// Watcher notices changes of files by polling their modification time and size. Created and removed files count too.
+type Watcher struct {
+	paths []string
+	stats map[string]fileStat
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewWatcher(paths ...string) *Watcher {
+	watcher := &Watcher{paths: paths}
+	watcher.Changed()
+	return watcher
+}
+
+// Changed reports whether any file changed since the last call.
+func (watcher *Watcher) Changed() (changed bool) {
+	stats := map[string]fileStat{}
+	for _, path := range watcher.paths {
+		info, err := os.Stat(path)
+		if err == nil {
+			stats[path] = fileStat{info.ModTime(), info.Size()}
+		}
+		if watcher.stats != nil && stats[path] != watcher.stats[path] {
+			changed = true
+		}
+	}
+	watcher.stats = stats
+	return
+}
+
+// Watch calls onChange after every change until ctx is done.
+func (watcher *Watcher) Watch(ctx context.Context, interval time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if watcher.Changed() {
+				onChange()
+			}
+		}
+	}
+}
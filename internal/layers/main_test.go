@@ -0,0 +1,118 @@
+package layers
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	NordPool struct {
+		MaxPrice float64   `yaml:"max-price"`
+		Timezone string    `yaml:"timezone"`
+		Fee      testPrice `yaml:"fee"`
+	} `yaml:"nord-pool"`
+	Site struct {
+		MaxCurrent *int `yaml:"max-current"`
+	} `yaml:"site"`
+	Chargers []struct {
+		Name string `yaml:"name"`
+	} `yaml:"chargers"`
+}
+
+// testPrice is a struct written as a scalar, like a price with units.
+type testPrice struct {
+	Text string
+}
+
+func (price *testPrice) UnmarshalYAML(node *yaml.Node) error {
+	return node.Decode(&price.Text)
+}
+
+func TestReadFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "config.yaml")
+	overlay := filepath.Join(dir, "config.host.yaml")
+	_ = os.WriteFile(base, []byte("nord-pool:\n  max-price: 0.1\n  timezone: Europe/Vilnius\nchargers:\n  - name: a\n  - name: b\n"), 0600)
+	_ = os.WriteFile(overlay, []byte("nord-pool:\n  max-price: 0.2\nchargers:\n  - name: c\n"), 0600)
+	node, err := ReadFiles(base, overlay, filepath.Join(dir, "missing.yaml"))
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	var config testConfig
+	err = node.Decode(&config)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if config.NordPool.MaxPrice != 0.2 || config.NordPool.Timezone != "Europe/Vilnius" {
+		t.Errorf("Got nord-pool %+v", config.NordPool)
+	}
+	if len(config.Chargers) != 1 || config.Chargers[0].Name != "c" {
+		t.Errorf("Got chargers %+v, wanted the overlay list", config.Chargers)
+	}
+	_, err = ReadFiles(filepath.Join(dir, "missing.yaml"))
+	if err == nil {
+		t.Errorf("Read a missing base file")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	node, err := Parse([]byte("nord-pool:\n  max-price: 0.1\n"))
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	env := map[string]string{
+		"WNP_NORD_POOL_MAX_PRICE": "0.15",
+		"WNP_NORD_POOL_FEE":       "9 ct/kWh",
+		"WNP_SITE_MAX_CURRENT":    "16",
+		"WNP_CHARGERS_NAME":       "ignored",
+	}
+	applied := ApplyEnv(node, &testConfig{}, "WNP", func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	wantApplied := []string{"WNP_NORD_POOL_MAX_PRICE", "WNP_NORD_POOL_FEE", "WNP_SITE_MAX_CURRENT"}
+	if !reflect.DeepEqual(applied, wantApplied) {
+		t.Errorf("Got applied %v, wanted %v", applied, wantApplied)
+	}
+	var config testConfig
+	err = node.Decode(&config)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if config.NordPool.MaxPrice != 0.15 {
+		t.Errorf("Got price %f, wanted %f", config.NordPool.MaxPrice, 0.15)
+	}
+	if config.NordPool.Fee.Text != "9 ct/kWh" {
+		t.Errorf("Got fee %q, wanted the whole scalar", config.NordPool.Fee.Text)
+	}
+	if config.Site.MaxCurrent == nil || *config.Site.MaxCurrent != 16 {
+		t.Errorf("Got max current %v, wanted 16", config.Site.MaxCurrent)
+	}
+}
+
+func TestWatcherChanged(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	overlay := filepath.Join(dir, "config.host.yaml")
+	_ = os.WriteFile(path, []byte("a: 1\n"), 0600)
+	watcher := NewWatcher(path, overlay)
+	if watcher.Changed() {
+		t.Errorf("Changed without a change")
+	}
+	_ = os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	if !watcher.Changed() {
+		t.Errorf("Modification not noticed")
+	}
+	_ = os.WriteFile(overlay, []byte("a: 2\n"), 0600)
+	if !watcher.Changed() {
+		t.Errorf("Created overlay not noticed")
+	}
+	_ = os.Remove(overlay)
+	if !watcher.Changed() {
+		t.Errorf("Removed overlay not noticed")
+	}
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Keys that are always redacted, whatever is logged under them.
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"jwt":           true,
+	"token":         true,
+	"authorization": true,
+	"secret":        true,
+}
+
+var handler slog.Handler = slog.Default().Handler()
+
+// Setup sets the default logger from LOG_LEVEL (debug, info, warn, error) and LOG_FORMAT (json, text).
+// Logs are JSON in Lambda and text otherwise.
+func Setup() {
+	format := os.Getenv("LOG_FORMAT")
+	if format == "" && os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+		format = "json"
+	}
+	SetupWith(os.Stderr, ParseLevel(os.Getenv("LOG_LEVEL")), format == "json")
+}
+
+func SetupWith(w io.Writer, level slog.Level, json bool) {
+	handler = NewHandler(w, level, json)
+	slog.SetDefault(slog.New(handler))
+}
+
+func NewHandler(w io.Writer, level slog.Level, json bool) slog.Handler {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	if json {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+// ParseLevel returns info for an empty or unknown level.
+func ParseLevel(level string) slog.Level {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(level))
+	if err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// StartRun adds the run ID to the default logger, the Lambda request ID when available.
+func StartRun(ctx context.Context) (runId string) {
+	runId = newRunId(ctx)
+	slog.SetDefault(slog.New(handler).With("run", runId))
+	return
+}
+
+func newRunId(ctx context.Context) string {
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		return lc.AwsRequestID
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, "***")
+	}
+	return attr
+}
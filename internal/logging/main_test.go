@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	var out strings.Builder
+	logger := slog.New(NewHandler(&out, slog.LevelInfo, false))
+	logger.Info("Login", "username", "me", "password", "hunter2", slog.Group("token", "jwt", "eyJ"), "Authorization", "Bearer eyJ")
+	for _, secret := range []string{"hunter2", "eyJ"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Secret %s logged in %s", secret, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "username=me") {
+		t.Errorf("Username not logged in %s", out.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level string
+		want  slog.Level
+	}{
+		{level: "", want: slog.LevelInfo},
+		{level: "debug", want: slog.LevelDebug},
+		{level: "WARN", want: slog.LevelWarn},
+		{level: "verbose", want: slog.LevelInfo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			if got := ParseLevel(tt.level); got != tt.want {
+				t.Errorf("ParseLevel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartRun(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+	if runId := StartRun(ctx); runId != "request-1" {
+		t.Errorf("Got run ID %s, wanted request-1", runId)
+	}
+	if runId := StartRun(context.Background()); len(runId) != 16 {
+		t.Errorf("Got run ID %s, wanted a random one", runId)
+	}
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+const defaultNamespace = "WallboxNordPool"
+
+type Config struct {
+	// Emf prints a CloudWatch Embedded Metric Format document at the end of each run.
+	Emf       bool   `yaml:"emf"`
+	Namespace string `yaml:"namespace"`
+}
+
+// EMF is a CloudWatch Embedded Metric Format document without dimensions.
+type EMF struct {
+	namespace  string
+	timestamp  time.Time
+	units      map[string]string
+	names      []string
+	values     map[string][]float64
+	properties map[string]any
+}
+
+func NewEMF(config Config, timestamp time.Time) *EMF {
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return &EMF{namespace: namespace, timestamp: timestamp, units: map[string]string{}, values: map[string][]float64{}, properties: map[string]any{}}
+}
+
+// Metric adds values to the metric, CloudWatch aggregates all values of one document.
+func (emf *EMF) Metric(name string, unit string, values ...float64) {
+	if _, ok := emf.units[name]; !ok {
+		emf.names = append(emf.names, name)
+		emf.units[name] = unit
+	}
+	emf.values[name] = append(emf.values[name], values...)
+}
+
+// Property adds a value that is searchable in the logs but is not a metric.
+func (emf *EMF) Property(name string, value any) {
+	emf.properties[name] = value
+}
+
+// Calls adds the latency of every call as the <Upstream>Latency metric.
+func (emf *EMF) Calls(calls []Call) {
+	for _, call := range calls {
+		name := strings.ToUpper(call.Upstream[:1]) + call.Upstream[1:] + "Latency"
+		emf.Metric(name, "Milliseconds", float64(call.Duration.Milliseconds()))
+	}
+}
+
+func (emf *EMF) Write(w io.Writer) error {
+	type metric struct {
+		Name string `json:"Name"`
+		Unit string `json:"Unit"`
+	}
+	document := map[string]any{}
+	for name, value := range emf.properties {
+		document[name] = value
+	}
+	metrics := make([]metric, 0, len(emf.names))
+	for _, name := range emf.names {
+		metrics = append(metrics, metric{name, emf.units[name]})
+		if len(emf.values[name]) == 1 {
+			document[name] = emf.values[name][0]
+		} else {
+			document[name] = emf.values[name]
+		}
+	}
+	document["_aws"] = map[string]any{
+		"Timestamp": emf.timestamp.UnixMilli(),
+		"CloudWatchMetrics": []map[string]any{{
+			"Namespace":  emf.namespace,
+			"Dimensions": [][]string{{}},
+			"Metrics":    metrics,
+		}},
+	}
+	return json.NewEncoder(w).Encode(document)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEMFWrite(t *testing.T) {
+	emf := NewEMF(Config{Emf: true}, time.UnixMilli(1690840800000))
+	emf.Metric("Price", "None", 0.1, 0.2)
+	emf.Calls([]Call{{Upstream: "wallbox", Code: "200", Duration: 150 * time.Millisecond}})
+	emf.Property("Actions", map[string]string{"garage": "pause"})
+	var text strings.Builder
+	err := emf.Write(&text)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	var got map[string]any
+	err = json.Unmarshal([]byte(text.String()), &got)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	want := map[string]any{
+		"Price":          []any{0.1, 0.2},
+		"WallboxLatency": 150.0,
+		"Actions":        map[string]any{"garage": "pause"},
+		"_aws": map[string]any{
+			"Timestamp": 1690840800000.0,
+			"CloudWatchMetrics": []any{map[string]any{
+				"Namespace":  "WallboxNordPool",
+				"Dimensions": []any{[]any{}},
+				"Metrics": []any{
+					map[string]any{"Name": "Price", "Unit": "None"},
+					map[string]any{"Name": "WallboxLatency", "Unit": "Milliseconds"},
+				},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, wanted %v", got, want)
+	}
+}
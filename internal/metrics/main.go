@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const namespace = "wallbox_nord_pool"
+
+type kind string
+
+const (
+	gauge   kind = "gauge"
+	counter kind = "counter"
+)
+
+type family struct {
+	help   string
+	kind   kind
+	values map[string]float64
+}
+
+// Registry keeps metrics in memory and writes them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	calls    []Call
+}
+
+// Call is one upstream HTTP call.
+type Call struct {
+	Upstream string
+	Code     string
+	Duration time.Duration
+}
+
+// Default is the registry used by the package functions.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// SetGauge sets the gauge for labels given as name, value pairs.
+func SetGauge(name string, help string, value float64, labels ...string) {
+	Default.SetGauge(name, help, value, labels...)
+}
+
+// AddCounter adds value to the counter for labels given as name, value pairs.
+func AddCounter(name string, help string, value float64, labels ...string) {
+	Default.AddCounter(name, help, value, labels...)
+}
+
+// TakeCalls returns the upstream calls made since the last TakeCalls.
+func TakeCalls() []Call {
+	return Default.TakeCalls()
+}
+
+func Handler() http.Handler {
+	return Default
+}
+
+func (registry *Registry) SetGauge(name string, help string, value float64, labels ...string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.family(name, help, gauge).values[renderLabels(labels)] = value
+}
+
+func (registry *Registry) AddCounter(name string, help string, value float64, labels ...string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.family(name, help, counter).values[renderLabels(labels)] += value
+}
+
+func (registry *Registry) TakeCalls() (calls []Call) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	calls, registry.calls = registry.calls, nil
+	return
+}
+
+func (registry *Registry) addCall(call Call) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.calls = append(registry.calls, call)
+}
+
+func (registry *Registry) family(name string, help string, kind kind) *family {
+	f, ok := registry.families[name]
+	if !ok {
+		f = &family{help: help, kind: kind, values: map[string]float64{}}
+		registry.families[name] = f
+	}
+	return f
+}
+
+func (registry *Registry) WriteText(w io.Writer) (err error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	names := make([]string, 0, len(registry.families))
+	for name := range registry.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := registry.families[name]
+		fullName := fmt.Sprintf("%s_%s", namespace, name)
+		_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", fullName, f.help, fullName, f.kind)
+		if err != nil {
+			return
+		}
+		keys := make([]string, 0, len(f.values))
+		for key := range f.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			_, err = fmt.Fprintf(w, "%s%s %s\n", fullName, key, strconv.FormatFloat(f.values[key], 'g', -1, 64))
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = registry.WriteText(w)
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+// NewHttpClient returns a client counting requests to the upstream by status code.
+func NewHttpClient(upstream string) *http.Client {
+	return &http.Client{Transport: transport{upstream, http.DefaultTransport}}
+}
+
+type transport struct {
+	upstream string
+	next     http.RoundTripper
+}
+
+func (t transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	start := time.Now()
+	resp, err = t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	AddCounter("http_requests_total", "HTTP requests per upstream and status code.", 1, "upstream", t.upstream, "code", code)
+	duration := time.Since(start)
+	AddCounter("http_request_seconds_total", "Time spent in HTTP requests per upstream.", duration.Seconds(), "upstream", t.upstream)
+	Default.addCall(Call{t.upstream, code, duration})
+	return
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	registry.SetGauge("price", "Price.", 0.1, "charger", "garage")
+	registry.SetGauge("price", "Price.", 0.2, "charger", "garage")
+	registry.AddCounter("actions_total", "Actions.", 1, "charger", "garage", "action", "pause")
+	registry.AddCounter("actions_total", "Actions.", 1, "charger", "garage", "action", "pause")
+	registry.AddCounter("cache_total", "Cache.", 1)
+	var text strings.Builder
+	err := registry.WriteText(&text)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	want := `# HELP wallbox_nord_pool_actions_total Actions.
+# TYPE wallbox_nord_pool_actions_total counter
+wallbox_nord_pool_actions_total{charger="garage",action="pause"} 2
+# HELP wallbox_nord_pool_cache_total Cache.
+# TYPE wallbox_nord_pool_cache_total counter
+wallbox_nord_pool_cache_total 1
+# HELP wallbox_nord_pool_price Price.
+# TYPE wallbox_nord_pool_price gauge
+wallbox_nord_pool_price{charger="garage"} 0.2
+`
+	if text.String() != want {
+		t.Errorf("Got\n%s\nwanted\n%s", text.String(), want)
+	}
+}
+
+func TestHttpClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+	resp, err := NewHttpClient("test").Get(server.URL)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	resp.Body.Close()
+	var text strings.Builder
+	_ = Default.WriteText(&text)
+	if !strings.Contains(text.String(), `wallbox_nord_pool_http_requests_total{upstream="test",code="418"} 1`) {
+		t.Errorf("Request not counted in\n%s", text.String())
+	}
+}
@@ -0,0 +1,172 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+type Currency string
+
+const (
+	EUR Currency = "EUR"
+	SEK Currency = "SEK"
+	NOK Currency = "NOK"
+	DKK Currency = "DKK"
+)
+
+// Unit is the energy a price is per.
+type Unit string
+
+const (
+	Wh  Unit = "Wh"
+	KWh Unit = "kWh"
+	MWh Unit = "MWh"
+)
+
+// kWhPer is how many kWh a unit is.
+var kWhPer = map[Unit]float64{Wh: 0.001, KWh: 1, MWh: 1000}
+
+// minorUnits are hundredths of a currency written instead of it, for example 10 ct/kWh.
+var minorUnits = map[string]Currency{"ct": EUR, "c": EUR, "snt": EUR, "öre": SEK, "ore": SEK, "øre": NOK}
+
+var (
+	errInvalidPrice = errors.New("invalid price, expected for example 0.10 EUR/kWh, 10 ct/kWh or 95 EUR/MWh")
+	errUnknownUnit  = errors.New("unknown energy unit")
+	errNoRate       = errors.New("no exchange rate")
+)
+
+// Price is money per energy. Config prices written as plain numbers have no currency and unit:
+// they are in the configured currency per kWh.
+type Price struct {
+	Amount   float64
+	Currency Currency
+	Unit     Unit
+}
+
+func New(amount float64, currency Currency, unit Unit) Price {
+	return Price{Amount: amount, Currency: currency, Unit: unit}
+}
+
+// PerKWh is a price in the configured currency per kWh, as a plain number in the config.
+func PerKWh(amount float64) Price {
+	return Price{Amount: amount}
+}
+
+// Parse reads prices like 0.10 EUR/kWh, 10 ct/kWh or 95 EUR/MWh.
+func Parse(text string) (price Price, err error) {
+	money, unit, ok := strings.Cut(strings.TrimSpace(text), "/")
+	fields := strings.Fields(money)
+	if !ok || len(fields) != 2 {
+		return Price{}, fmt.Errorf("%s : %w", text, errInvalidPrice)
+	}
+	price.Amount, err = strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Price{}, fmt.Errorf("%s : %w", text, errInvalidPrice)
+	}
+	price.Unit, err = parseUnit(unit)
+	if err != nil {
+		return Price{}, fmt.Errorf("%s : %w", text, err)
+	}
+	if currency, ok := minorUnits[strings.ToLower(fields[1])]; ok {
+		price.Amount /= 100
+		price.Currency = currency
+		return
+	}
+	if len(fields[1]) != 3 {
+		return Price{}, fmt.Errorf("%s : %w", text, errInvalidPrice)
+	}
+	price.Currency = Currency(strings.ToUpper(fields[1]))
+	return
+}
+
+func parseUnit(text string) (Unit, error) {
+	for unit := range kWhPer {
+		if strings.EqualFold(string(unit), strings.TrimSpace(text)) {
+			return unit, nil
+		}
+	}
+	return "", fmt.Errorf("%s : %w", text, errUnknownUnit)
+}
+
+// In converts the price to unit, prices without unit are per kWh.
+func (price Price) In(unit Unit) Price {
+	from, ok := kWhPer[price.Unit]
+	if !ok {
+		from = 1
+	}
+	to, ok := kWhPer[unit]
+	if !ok {
+		unit, to = KWh, 1
+	}
+	return Price{Amount: price.Amount / from * to, Currency: price.Currency, Unit: unit}
+}
+
+// Convert exchanges the price to currency, prices without currency are already in it.
+func (price Price) Convert(currency Currency, rates Rates) (Price, error) {
+	if price.Currency == "" || price.Currency == currency {
+		price.Currency = currency
+		return price, nil
+	}
+	if rates == nil {
+		return Price{}, fmt.Errorf("%s to %s : %w", price.Currency, currency, errNoRate)
+	}
+	rate, err := rates.Rate(price.Currency, currency)
+	if err != nil {
+		return Price{}, err
+	}
+	return Price{Amount: price.Amount * rate, Currency: currency, Unit: price.Unit}, nil
+}
+
+// Value is the amount of the price in currency per unit.
+func (price Price) Value(currency Currency, unit Unit, rates Rates) (value float64, err error) {
+	converted, err := price.Convert(currency, rates)
+	if err != nil {
+		return
+	}
+	return converted.In(unit).Amount, nil
+}
+
+// Number is the amount per kWh, so that min and max rules of the config check it.
+func (price Price) Number() float64 {
+	return price.In(KWh).Amount
+}
+
+func (price Price) String() string {
+	amount := strconv.FormatFloat(price.Amount, 'f', -1, 64)
+	if price.Currency == "" {
+		return fmt.Sprintf("%s/%s", amount, price.In(price.Unit).Unit)
+	}
+	return fmt.Sprintf("%s %s/%s", amount, price.Currency, price.In(price.Unit).Unit)
+}
+
+// UnmarshalYAML takes a plain number, in the configured currency per kWh, or a price with its units.
+func (price *Price) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind == yaml.ScalarNode && node.ShortTag() != "!!str" {
+		var amount float64
+		if err = node.Decode(&amount); err == nil {
+			*price = PerKWh(amount)
+			return
+		}
+	}
+	var text string
+	err = node.Decode(&text)
+	if err != nil {
+		return
+	}
+	*price, err = Parse(text)
+	if err != nil {
+		// a type error lets the decoder report the line and go on with the other keys
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: %s", node.Line, err)}}
+	}
+	return
+}
+
+func (price Price) MarshalYAML() (any, error) {
+	if price.Currency == "" && (price.Unit == "" || price.Unit == KWh) {
+		return price.Amount, nil
+	}
+	return price.String(), nil
+}
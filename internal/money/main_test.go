@@ -0,0 +1,111 @@
+package money
+
+import (
+	"gopkg.in/yaml.v3"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Price
+		wantErr bool
+	}{
+		{text: "0.10 EUR/kWh", want: New(0.10, EUR, KWh)},
+		{text: "95 eur/MWh", want: New(95, EUR, MWh)},
+		{text: "10 ct/kWh", want: New(0.10, EUR, KWh)},
+		{text: "120 öre/kWh", want: New(1.2, SEK, KWh)},
+		{text: "0.5 NOK / kwh", want: New(0.5, NOK, KWh)},
+		{text: "0.10 EUR", wantErr: true},
+		{text: "0.10 EUR/GJ", wantErr: true},
+		{text: "cheap EUR/kWh", wantErr: true},
+		{text: "0.10 euro/kWh", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got Error %v, wanted error %t", err, tt.wantErr)
+			}
+			if got.Currency != tt.want.Currency || got.Unit != tt.want.Unit || math.Abs(got.Amount-tt.want.Amount) > 1e-9 {
+				t.Errorf("Got price %s, wanted %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	rates := FixedRates{Rates: map[Currency]float64{SEK: 11.5, NOK: 11.75}}
+	tests := []struct {
+		name     string
+		price    Price
+		currency Currency
+		unit     Unit
+		want     float64
+		wantErr  bool
+	}{
+		{name: "Pool price", price: New(95, EUR, MWh), currency: EUR, unit: KWh, want: 0.095},
+		{name: "Plain number", price: PerKWh(0.05), currency: SEK, unit: KWh, want: 0.05},
+		{name: "To base", price: New(1.15, SEK, KWh), currency: EUR, unit: KWh, want: 0.1},
+		{name: "Cross rate", price: New(1.15, SEK, KWh), currency: NOK, unit: MWh, want: 1175},
+		{name: "Per Wh", price: New(0.0001, EUR, Wh), currency: EUR, unit: KWh, want: 0.1},
+		{name: "No rate", price: New(1, DKK, KWh), currency: EUR, unit: KWh, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.price.Value(tt.currency, tt.unit, rates)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got Error %v, wanted error %t", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Got price %f, wanted %f", got, tt.want)
+			}
+		})
+	}
+	if _, err := New(1, SEK, KWh).Convert(EUR, nil); err == nil {
+		t.Errorf("Got no error converting without rates")
+	}
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	var config struct {
+		Number Price `yaml:"number"`
+		Units  Price `yaml:"units"`
+		Quoted Price `yaml:"quoted"`
+	}
+	err := yaml.Unmarshal([]byte("number: 0.05\nunits: 9 ct/kWh\nquoted: \"0.2\"\n"), &config)
+	if err == nil {
+		t.Errorf("Got no error for a quoted number without units")
+	}
+	if config.Number != PerKWh(0.05) || config.Units.Currency != EUR || math.Abs(config.Units.Amount-0.09) > 1e-9 {
+		t.Errorf("Got prices %+v", config)
+	}
+	out, err := yaml.Marshal(map[string]Price{"a": PerKWh(0.05), "b": New(95, EUR, MWh)})
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if string(out) != "a: 0.05\nb: 95 EUR/MWh\n" {
+		t.Errorf("Got yaml %q", out)
+	}
+}
+
+func TestNewRates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"amount":1.0,"base":"EUR","date":"2024-01-02","rates":{"NOK":11.6,"SEK":11.2}}`))
+	}))
+	defer server.Close()
+	rates, err := NewRates(FxConfig{Url: server.URL, Rates: map[Currency]float64{SEK: 11.5}})
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if rates.Rates[NOK] != 11.6 || rates.Rates[SEK] != 11.5 {
+		t.Errorf("Got rates %v, wanted fetched NOK and fixed SEK", rates.Rates)
+	}
+	_, err = NewRates(FxConfig{Url: server.URL, Field: "data.rates"})
+	if err == nil {
+		t.Errorf("Got no error for a missing field")
+	}
+}
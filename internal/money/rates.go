@@ -0,0 +1,126 @@
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"wallbox_nord_pool/internal/jsonpath"
+	"wallbox_nord_pool/internal/metrics"
+)
+
+const defaultFxTimeout = 5 * time.Second
+
+var httpClient = metrics.NewHttpClient("fx")
+
+// Rates exchanges currencies.
+type Rates interface {
+	// Rate is the amount of to that one unit of from buys.
+	Rate(from Currency, to Currency) (float64, error)
+}
+
+// FxConfig sets exchange rates against Base, fixed ones or read from a JSON endpoint once per run.
+// Fixed rates win over fetched ones.
+type FxConfig struct {
+	// Base is the currency the rates are quoted against, EUR when empty.
+	Base  Currency             `yaml:"base"`
+	Rates map[Currency]float64 `yaml:"rates"`
+	// Url returns the rates, for example https://api.frankfurter.app/latest?from=EUR.
+	Url string `yaml:"url"`
+	// Field is the dot separated path of the object of rates in the response, rates when empty.
+	Field   string        `yaml:"field"`
+	Timeout time.Duration `yaml:"timeout" validate:"min=0"`
+}
+
+// FixedRates are units of each currency per one unit of the base currency.
+type FixedRates struct {
+	Base  Currency
+	Rates map[Currency]float64
+}
+
+func (rates FixedRates) Rate(from Currency, to Currency) (rate float64, err error) {
+	if from == to {
+		return 1, nil
+	}
+	fromRate, err := rates.perBase(from)
+	if err != nil {
+		return
+	}
+	toRate, err := rates.perBase(to)
+	if err != nil {
+		return
+	}
+	return toRate / fromRate, nil
+}
+
+func (rates FixedRates) perBase(currency Currency) (float64, error) {
+	base := rates.Base
+	if base == "" {
+		base = EUR
+	}
+	if currency == base {
+		return 1, nil
+	}
+	rate, ok := rates.Rates[currency]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("%s per %s : %w", currency, base, errNoRate)
+	}
+	return rate, nil
+}
+
+// NewRates reads the configured rates. Without any only same currency prices convert.
+func NewRates(config FxConfig) (rates FixedRates, err error) {
+	rates = FixedRates{Base: config.Base, Rates: map[Currency]float64{}}
+	if config.Url != "" {
+		rates.Rates, err = fetchRates(config)
+		if err != nil {
+			return
+		}
+	}
+	for currency, rate := range config.Rates {
+		rates.Rates[currency] = rate
+	}
+	return
+}
+
+func fetchRates(config FxConfig) (rates map[Currency]float64, err error) {
+	client := *httpClient
+	client.Timeout = config.Timeout
+	if client.Timeout <= 0 {
+		client.Timeout = defaultFxTimeout
+	}
+	resp, err := client.Get(config.Url)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s %s : %s", config.Url, resp.Status, body)
+	}
+	var document any
+	err = json.NewDecoder(resp.Body).Decode(&document)
+	if err != nil {
+		return
+	}
+	field := config.Field
+	if field == "" {
+		field = "rates"
+	}
+	found, err := jsonpath.Lookup(document, field)
+	if err != nil {
+		return
+	}
+	object, ok := found.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s : %w", field, jsonpath.ErrNotFound)
+	}
+	rates = map[Currency]float64{}
+	for currency, value := range object {
+		if rate, ok := value.(float64); ok {
+			rates[Currency(currency)] = rate
+		}
+	}
+	return
+}
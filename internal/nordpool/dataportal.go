@@ -0,0 +1,191 @@
+package nordpool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"wallbox_nord_pool/internal/metrics"
+)
+
+const (
+	SourceElering    = "elering"
+	SourceDataPortal = "data-portal"
+	// deliveryTimezone is where the delivery days of the day-ahead market start, CET.
+	deliveryTimezone = "Europe/Oslo"
+)
+
+// dataPortalUrl is the day-ahead prices endpoint of the Nord Pool Data Portal.
+var dataPortalUrl = "https://dataportal-api.nordpoolgroup.com/api/DayAheadPrices"
+
+var dataPortalClient = metrics.NewHttpClient("nordpool")
+
+var (
+	errPricesNotPublished = errors.New("prices not published yet")
+	errUnexpectedCurrency = errors.New("unexpected currency")
+)
+
+// zones are the bidding zones of each source.
+var zones = map[string][]string{
+	SourceElering:    {"ee", "fi", "lt", "lv"},
+	SourceDataPortal: {"ee", "fi", "lt", "lv", "se1", "se2", "se3", "se4", "no1", "no2", "no3", "no4", "no5", "dk1", "dk2"},
+}
+
+// Zones returns the bidding zones of the source, of Elering when empty.
+func Zones(source string) []string {
+	if source == "" {
+		source = SourceElering
+	}
+	return zones[source]
+}
+
+// HasZone tells whether the source publishes the zone, lt when empty.
+func HasZone(source string, zone string) bool {
+	return slices.Contains(Zones(source), zoneName(zone))
+}
+
+// dataPortalPrices is a day-ahead response of the Data Portal, the prices of one delivery day per area.
+type dataPortalPrices struct {
+	DeliveryDate     string `json:"deliveryDateCET"`
+	Currency         string `json:"currency"`
+	MultiAreaEntries []struct {
+		DeliveryStart time.Time          `json:"deliveryStart"`
+		DeliveryEnd   time.Time          `json:"deliveryEnd"`
+		EntryPerArea  map[string]float64 `json:"entryPerArea"`
+	} `json:"multiAreaEntries"`
+}
+
+// Zone returns the prices of the zone, EUR/MWh, as published: hourly or in 15 minute slots.
+func (prices dataPortalPrices) Zone(zone string) (zonePrices []Price, err error) {
+	if prices.Currency != string(poolCurrency) {
+		return nil, fmt.Errorf("%s %s : %w", prices.DeliveryDate, prices.Currency, errUnexpectedCurrency)
+	}
+	area := strings.ToUpper(zoneName(zone))
+	for _, entry := range prices.MultiAreaEntries {
+		price, ok := entry.EntryPerArea[area]
+		if !ok {
+			continue
+		}
+		zonePrices = append(zonePrices, Price{
+			Timestamp: entry.DeliveryStart.Unix(),
+			Duration:  entry.DeliveryEnd.Sub(entry.DeliveryStart),
+			Price:     price,
+		})
+	}
+	if len(zonePrices) == 0 {
+		return nil, fmt.Errorf("%s : %w", zone, errUnknownZone)
+	}
+	return
+}
+
+// getDataPortalPrices returns the zone prices of a day from locationDate, from the delivery days it spans.
+// Days not published yet are missing, like slots Elering has no prices for.
+func getDataPortalPrices(s3svc *s3.S3, awsS3Bucket string, zone string, locationDate time.Time) (zonePrices []Price, err error) {
+	start := time.Date(locationDate.Year(), locationDate.Month(), locationDate.Day(), locationDate.Hour(), 0, 0, 0, locationDate.Location())
+	end := start.AddDate(0, 0, 1)
+	days, err := deliveryDays(start, end)
+	if err != nil {
+		return
+	}
+	for _, day := range days {
+		dayPrices, err := getDeliveryDay(s3svc, awsS3Bucket, zone, day)
+		if errors.Is(err, errPricesNotPublished) {
+			slog.Debug("Prices not published yet", "date", day.Format(time.DateOnly), "zone", zoneName(zone))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, price := range dayPrices {
+			if !price.Start().Before(start) && price.Start().Before(end) {
+				zonePrices = append(zonePrices, price)
+			}
+		}
+	}
+	return Normalize(zonePrices), nil
+}
+
+// deliveryDays returns the delivery days, CET midnights, that the interval from start till end overlaps.
+func deliveryDays(start time.Time, end time.Time) (days []time.Time, err error) {
+	location, err := time.LoadLocation(deliveryTimezone)
+	if err != nil {
+		return
+	}
+	first := start.In(location)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, location); day.Before(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return
+}
+
+// getDeliveryDay returns the zone prices of a delivery day. Only published days are cached.
+func getDeliveryDay(s3svc *s3.S3, awsS3Bucket string, zone string, day time.Time) (zonePrices []Price, err error) {
+	fileName := dataPortalFileName(zone, day)
+	pricesBytes, err := readCache(s3svc, awsS3Bucket, fileName)
+	if err != nil {
+		if !errors.Is(err, errPricesFileDoesNotExist) {
+			return
+		}
+		pricesBytes, err = fetchDataPortal(zone, day)
+		if err != nil {
+			return
+		}
+		zonePrices, err = parseDataPortal(pricesBytes, zone)
+		if err != nil {
+			return
+		}
+		err = writeCache(s3svc, awsS3Bucket, fileName, pricesBytes)
+		return
+	}
+	return parseDataPortal(pricesBytes, zone)
+}
+
+func parseDataPortal(pricesBytes []byte, zone string) (zonePrices []Price, err error) {
+	var prices dataPortalPrices
+	err = json.Unmarshal(pricesBytes, &prices)
+	if err != nil {
+		return
+	}
+	return prices.Zone(zone)
+}
+
+// fetchDataPortal fetches the EUR prices of the zone for the delivery day. Nord Pool answers No Content till
+// the day is published, around 13:00 CET the day before.
+func fetchDataPortal(zone string, day time.Time) (pricesBytes []byte, err error) {
+	req, err := http.NewRequest("GET", dataPortalUrl, nil)
+	if err != nil {
+		return
+	}
+	area := strings.ToUpper(zoneName(zone))
+	q := req.URL.Query()
+	q.Add("date", day.Format(time.DateOnly))
+	q.Add("market", "DayAhead")
+	q.Add("deliveryArea", area)
+	q.Add("currency", string(poolCurrency))
+	req.URL.RawQuery = q.Encode()
+	slog.Info("Fetching prices", "source", SourceDataPortal, "date", q.Get("date"), "zone", area)
+	resp, err := dataPortalClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNoContent:
+		return nil, fmt.Errorf("%s %s : %w", q.Get("date"), area, errPricesNotPublished)
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s %s : %s", dataPortalUrl, resp.Status, body)
+	}
+}
+
+func dataPortalFileName(zone string, day time.Time) string {
+	return fmt.Sprintf("nord_pool_data_portal_%s_%s.json", zoneName(zone), day.Format(time.DateOnly))
+}
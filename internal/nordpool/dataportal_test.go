@@ -0,0 +1,140 @@
+package nordpool
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDataPortalZone(t *testing.T) {
+	tests := []struct {
+		name         string
+		fixture      string
+		zone         string
+		wantCount    int
+		wantFirst    float64
+		wantDuration time.Duration
+		wantErr      error
+	}{
+		{name: "Hourly on the DST change", fixture: "testdata/dataportal_2024-10-27_SE3_NO1.json", zone: "se3", wantCount: 25, wantFirst: 55, wantDuration: time.Hour},
+		{name: "Other area", fixture: "testdata/dataportal_2024-10-27_SE3_NO1.json", zone: "NO1", wantCount: 25, wantFirst: 67.95, wantDuration: time.Hour},
+		{name: "15 minute slots", fixture: "testdata/dataportal_2025-10-02_DK1.json", zone: "dk1", wantCount: 96, wantFirst: 55, wantDuration: 15 * time.Minute},
+		{name: "Area not in the response", fixture: "testdata/dataportal_2025-10-02_DK1.json", zone: "se3", wantErr: errUnknownZone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pricesBytes, err := os.ReadFile(tt.fixture)
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			prices, err := parseDataPortal(pricesBytes, tt.zone)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Got Error %v, wanted %v", err, tt.wantErr)
+			}
+			if len(prices) != tt.wantCount {
+				t.Fatalf("Got %d prices, wanted %d", len(prices), tt.wantCount)
+			}
+			if tt.wantCount == 0 {
+				return
+			}
+			if prices[0].Price != tt.wantFirst || prices[0].Duration != tt.wantDuration {
+				t.Errorf("Got first price %+v, wanted %f for %s", prices[0], tt.wantFirst, tt.wantDuration)
+			}
+			normalized := Normalize(prices)
+			for i := 1; i < len(normalized); i++ {
+				if !normalized[i-1].End().Equal(normalized[i].Start()) {
+					t.Errorf("Got a gap after %s", normalized[i-1].Start().UTC())
+				}
+			}
+		})
+	}
+}
+
+func TestFetchDataPortal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("market") != "DayAhead" || q.Get("currency") != "EUR" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fixture, err := os.ReadFile("testdata/dataportal_" + q.Get("date") + "_" + q.Get("deliveryArea") + ".json")
+		if err != nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write(fixture)
+	}))
+	defer server.Close()
+	defaultUrl := dataPortalUrl
+	dataPortalUrl = server.URL
+	defer func() { dataPortalUrl = defaultUrl }()
+
+	location, _ := time.LoadLocation(deliveryTimezone)
+	pricesBytes, err := fetchDataPortal("dk1", time.Date(2025, 10, 2, 0, 0, 0, 0, location))
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	prices, err := parseDataPortal(pricesBytes, "dk1")
+	if err != nil || len(prices) != 96 {
+		t.Errorf("Got %d prices, error %v", len(prices), err)
+	}
+	_, err = fetchDataPortal("dk1", time.Date(2025, 10, 3, 0, 0, 0, 0, location))
+	if !errors.Is(err, errPricesNotPublished) {
+		t.Errorf("Got Error %v, wanted %v", err, errPricesNotPublished)
+	}
+}
+
+func TestDeliveryDays(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Vilnius")
+	tests := []struct {
+		name  string
+		start time.Time
+		want  []string
+	}{
+		{name: "Midnight east of CET", start: time.Date(2024, 10, 27, 0, 0, 0, 0, location), want: []string{"2024-10-26", "2024-10-27"}},
+		{name: "Afternoon", start: time.Date(2024, 10, 27, 14, 0, 0, 0, location), want: []string{"2024-10-27", "2024-10-28"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, err := deliveryDays(tt.start, tt.start.AddDate(0, 0, 1))
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			var got []string
+			for _, day := range days {
+				got = append(got, day.Format(time.DateOnly))
+			}
+			if len(got) != len(tt.want) || got[0] != tt.want[0] || got[len(got)-1] != tt.want[len(tt.want)-1] {
+				t.Errorf("Got days %v, wanted %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasZone(t *testing.T) {
+	if !HasZone("", "") || !HasZone(SourceElering, "EE") || HasZone(SourceElering, "se3") || !HasZone(SourceDataPortal, "se3") {
+		t.Errorf("Got wrong zones, elering %v, data portal %v", Zones(SourceElering), Zones(SourceDataPortal))
+	}
+}
+
+func TestSourceOrDefault(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: "", want: SourceElering},
+		{source: "elering", want: SourceElering},
+		{source: "data-portal", want: SourceDataPortal},
+		{source: "Data-Portal", want: SourceDataPortal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			if got := (NordPoolConfig{Source: tt.source}).SourceOrDefault(); got != tt.want {
+				t.Errorf("Got source %s, wanted %s", got, tt.want)
+			}
+		})
+	}
+}
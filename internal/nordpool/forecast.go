@@ -0,0 +1,84 @@
+package nordpool
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log/slog"
+	"time"
+)
+
+// ForecastConfig fills slots that are not published yet with the average of the same weekday
+// and time over the last Weeks weeks. Forecast prices are used for the desired price only when trusted.
+type ForecastConfig struct {
+	Weeks int  `yaml:"weeks" validate:"min=0"`
+	Trust bool `yaml:"trust"`
+}
+
+// dayLoader returns the zone prices of the whole day of date.
+type dayLoader func(date time.Time) ([]Price, error)
+
+// cachedDays loads the days through GetDayPrices.
+func cachedDays(s3svc *s3.S3, awsS3Bucket string, config NordPoolConfig) dayLoader {
+	return func(date time.Time) ([]Price, error) {
+		return GetDayPrices(s3svc, awsS3Bucket, date, config)
+	}
+}
+
+// withForecast fills the missing slots from locationDate till the deadline from the same days of the previous weeks.
+func withForecast(loadDay dayLoader, config NordPoolConfig, prices []Price, locationDate time.Time) (forecast []Price, err error) {
+	if config.Forecast.Weeks <= 0 {
+		return prices, nil
+	}
+	deadline, err := Deadline(config, locationDate)
+	if err != nil {
+		return
+	}
+	if !missingSlots(prices, locationDate, deadline) {
+		return prices, nil
+	}
+	midnight := time.Date(locationDate.Year(), locationDate.Month(), locationDate.Day(), 0, 0, 0, 0, locationDate.Location())
+	var history []Price
+	for week := 1; week <= config.Forecast.Weeks; week++ {
+		for day := midnight; day.Before(deadline); day = day.AddDate(0, 0, 1) {
+			dayPrices, err := loadDay(day.AddDate(0, 0, -7*week))
+			if err != nil {
+				slog.Warn("No price history", "date", day.AddDate(0, 0, -7*week).Format(time.DateOnly), "error", err)
+				continue
+			}
+			history = append(history, dayPrices...)
+		}
+	}
+	return forecastPrices(prices, history, locationDate, deadline, config.Forecast.Weeks), nil
+}
+
+func missingSlots(prices []Price, from time.Time, till time.Time) bool {
+	for date := from.Truncate(defaultResolution); date.Before(till); date = date.Add(defaultResolution) {
+		if _, err := lookupPrice(prices, date); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// forecastPrices returns prices with the missing slots from till filled from history and flagged as forecast.
+// History may be of any resolution, the forecast is in 15 minute slots.
+func forecastPrices(prices []Price, history []Price, from time.Time, till time.Time, weeks int) (forecast []Price) {
+	forecast = append(forecast, prices...)
+	for date := from.Truncate(defaultResolution); date.Before(till); date = date.Add(defaultResolution) {
+		if _, err := lookupPrice(prices, date); err == nil {
+			continue
+		}
+		var sum float64
+		var count int
+		for week := 1; week <= weeks; week++ {
+			price, err := lookupPrice(history, date.AddDate(0, 0, -7*week))
+			if err == nil {
+				sum += price.Price
+				count++
+			}
+		}
+		if count > 0 {
+			forecast = append(forecast, Price{Timestamp: date.Unix(), Duration: defaultResolution, Price: sum / float64(count), Forecast: true})
+		}
+	}
+	return
+}
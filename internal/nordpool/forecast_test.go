@@ -0,0 +1,139 @@
+package nordpool
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestForecastPrices(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Vilnius")
+	from := time.Date(2023, 8, 15, 23, 0, 0, 0, location)
+	till := from.Add(time.Hour)
+	prices := []Price{{Timestamp: from.Unix(), Price: 100}}
+	history := []Price{
+		{Timestamp: from.AddDate(0, 0, -7).Add(15 * time.Minute).Unix(), Price: 40},
+		{Timestamp: from.AddDate(0, 0, -14).Add(15 * time.Minute).Unix(), Price: 60},
+		{Timestamp: from.AddDate(0, 0, -7).Add(30 * time.Minute).Unix(), Price: 70},
+		{Timestamp: from.AddDate(0, 0, -21).Add(30 * time.Minute).Unix(), Price: 10},
+	}
+	forecast := forecastPrices(prices, history, from, till, 2)
+	tests := []struct {
+		name         string
+		date         time.Time
+		wantPrice    float64
+		wantForecast bool
+		wantErr      bool
+	}{
+		{name: "Published", date: from, wantPrice: 100},
+		{name: "Average of two weeks", date: from.Add(15 * time.Minute), wantPrice: 50, wantForecast: true},
+		{name: "Older weeks ignored", date: from.Add(30 * time.Minute), wantPrice: 70, wantForecast: true},
+		{name: "No history", date: from.Add(45 * time.Minute), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := lookupPrice(forecast, tt.date)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, wanted error %t", err, tt.wantErr)
+			}
+			if math.Abs(p.Price-tt.wantPrice) > 0.001 || p.Forecast != tt.wantForecast {
+				t.Errorf("Got price %f forecast %t, wanted %f forecast %t", p.Price, p.Forecast, tt.wantPrice, tt.wantForecast)
+			}
+		})
+	}
+}
+
+func TestForecastPricesHourlyHistory(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Vilnius")
+	from := time.Date(2025, 10, 15, 23, 0, 0, 0, location)
+	history := Normalize([]Price{
+		{Timestamp: from.AddDate(0, 0, -7).Unix(), Price: 40},
+		{Timestamp: from.AddDate(0, 0, -7).Add(time.Hour).Unix(), Price: 80},
+	})
+	forecast := forecastPrices(nil, history, from, from.Add(time.Hour), 1)
+	if len(forecast) != 4 {
+		t.Fatalf("Got %d forecast slots, wanted 4", len(forecast))
+	}
+	for _, p := range forecast {
+		if p.Price != 40 || p.Duration != 15*time.Minute {
+			t.Errorf("Got price %f of %s, wanted 40 of 15m", p.Price, p.Duration)
+		}
+	}
+}
+
+func TestWithForecast(t *testing.T) {
+	config := NordPoolConfig{
+		ChargeTillHourDay:   18,
+		ChargeTillHourNight: 8,
+		Timezone:            "Europe/Vilnius",
+		Forecast:            ForecastConfig{Weeks: 1},
+	}
+	location, _ := time.LoadLocation(config.Timezone)
+	date := time.Date(2023, 8, 15, 23, 0, 0, 0, location)
+	prices := Normalize([]Price{{Timestamp: date.Unix(), Price: 100}})
+	// A day of history is priced by its day of month.
+	loadDay := func(day time.Time) (dayPrices []Price, err error) {
+		midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+		for hour := midnight; hour.Before(midnight.AddDate(0, 0, 1)); hour = hour.Add(time.Hour) {
+			dayPrices = append(dayPrices, Price{Timestamp: hour.Unix(), Price: float64(day.Day())})
+		}
+		return Normalize(dayPrices), nil
+	}
+	forecast, err := withForecast(loadDay, config, prices, date)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	tests := []struct {
+		name         string
+		date         time.Time
+		wantPrice    float64
+		wantForecast bool
+	}{
+		{name: "Published", date: date, wantPrice: 100},
+		{name: "Next day before the deadline", date: date.Add(4 * time.Hour), wantPrice: 9, wantForecast: true},
+		{name: "Last slot", date: date.Add(8*time.Hour + 45*time.Minute), wantPrice: 9, wantForecast: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := lookupPrice(forecast, tt.date)
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if p.Price != tt.wantPrice || p.Forecast != tt.wantForecast {
+				t.Errorf("Got price %f forecast %t, wanted %f forecast %t", p.Price, p.Forecast, tt.wantPrice, tt.wantForecast)
+			}
+		})
+	}
+}
+
+func TestFindMinPriceForecast(t *testing.T) {
+	config := NordPoolConfig{
+		ChargeTillHourDay:   18,
+		ChargeTillHourNight: 8,
+		Timezone:            "Europe/Vilnius",
+		TransmissionCost:    TransmissionCostConfig{Timezone: "Europe/Vilnius"},
+	}
+	location, _ := time.LoadLocation(config.Timezone)
+	date := time.Date(2023, 8, 1, 23, 0, 0, 0, location)
+	prices := []Price{{Timestamp: date.Unix(), Price: 100}, {Timestamp: date.Add(15 * time.Minute).Unix(), Price: 20, Forecast: true}}
+	tests := []struct {
+		name      string
+		trust     bool
+		wantPrice float64
+	}{
+		{name: "Trusted", trust: true, wantPrice: 0.02},
+		{name: "Not trusted", trust: false, wantPrice: 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Forecast = ForecastConfig{Weeks: 4, Trust: tt.trust}
+			p, err := findMinPrice(config, prices, date)
+			if err != nil {
+				t.Errorf("Got Error %s", err)
+			}
+			if math.Abs(p-tt.wantPrice) > 0.001 {
+				t.Errorf("Got price %f, wanted %f", p, tt.wantPrice)
+			}
+		})
+	}
+}
@@ -2,22 +2,75 @@ package nordpool
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"io"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
+	"wallbox_nord_pool/internal/metrics"
+	"wallbox_nord_pool/internal/money"
 )
 
+// Price is the pool price of the interval starting at Timestamp, Unix seconds.
+// Duration is set by Normalize, prices without it are 15 minute slots.
 type Price struct {
-	Timestamp int64   `json:"timestamp"`
-	Price     float64 `json:"price"`
+	Timestamp int64         `json:"timestamp"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Price     float64       `json:"price"`
+	Forecast  bool          `json:"forecast,omitempty"`
 }
+
+func (price Price) Start() time.Time {
+	return time.Unix(price.Timestamp, 0)
+}
+
+func (price Price) End() time.Time {
+	if price.Duration <= 0 {
+		return price.Start().Add(defaultResolution)
+	}
+	return price.Start().Add(price.Duration)
+}
+
+// Contains tells whether date is in the interval of the price.
+func (price Price) Contains(date time.Time) bool {
+	return !date.Before(price.Start()) && date.Before(price.End())
+}
+
+// Normalize sorts the prices and sets their durations up to the next price, so that hourly and 15 minute prices,
+// also mixed as on the day-ahead market switchover, are intervals. Longer gaps than an hour are missing prices:
+// the interval before them, like the last one, is as long as the one before it.
+func Normalize(prices []Price) (normalized []Price) {
+	normalized = slices.Clone(prices)
+	slices.SortStableFunc(normalized, func(a, b Price) int {
+		return cmp.Compare(a.Timestamp, b.Timestamp)
+	})
+	previous := defaultResolution
+	for i := range normalized {
+		if normalized[i].Duration > 0 {
+			previous = normalized[i].Duration
+			continue
+		}
+		duration := previous
+		if i+1 < len(normalized) {
+			gap := time.Duration(normalized[i+1].Timestamp-normalized[i].Timestamp) * time.Second
+			if gap > 0 && gap <= maxResolution {
+				duration = gap
+			}
+		}
+		normalized[i].Duration = duration
+		previous = duration
+	}
+	return
+}
+
 type Prices struct {
 	Success bool `json:"success"`
 	Data    struct {
@@ -29,20 +82,34 @@ type Prices struct {
 }
 
 type TransmissionCostConfig struct {
-	Day           float64 `yaml:"day"`
-	Night         float64 `yaml:"night"`
-	DayStartsAt   int     `yaml:"day-starts-at"`
-	NightStartsAt int     `yaml:"night-starts-at"`
-	Timezone      string  `yaml:"timezone"`
+	Day           money.Price `yaml:"day" validate:"min=0"`
+	Night         money.Price `yaml:"night" validate:"min=0"`
+	DayStartsAt   int         `yaml:"day-starts-at" validate:"min=0,max=23"`
+	NightStartsAt int         `yaml:"night-starts-at" validate:"min=0,max=23"`
+	Timezone      string      `yaml:"timezone" validate:"timezone"`
 }
 
+// NordPoolConfig prices are plain numbers in Currency per kWh or declare their units, for example 9 ct/kWh.
 type NordPoolConfig struct {
-	MaxPrice            float64                `yaml:"max-price"`
-	ChargeTillHourDay   int                    `yaml:"charge-till-hour-day"`
-	ChargeTillHourNight int                    `yaml:"charge-till-hour-night"`
-	Vat                 float64                `yaml:"vat"`
-	Timezone            string                 `yaml:"timezone"`
+	MaxPrice            money.Price            `yaml:"max-price" validate:"min=0"`
+	ChargeTillHourDay   int                    `yaml:"charge-till-hour-day" validate:"min=0,max=23"`
+	ChargeTillHourNight int                    `yaml:"charge-till-hour-night" validate:"min=0,max=23"`
+	Vat                 float64                `yaml:"vat" validate:"min=0,max=1"`
+	Timezone            string                 `yaml:"timezone" validate:"timezone"`
+	Zone                string                 `yaml:"zone" validate:"oneof=lt lv ee fi se1 se2 se3 se4 no1 no2 no3 no4 no5 dk1 dk2"`
 	TransmissionCost    TransmissionCostConfig `yaml:"transmission-cost"`
+	Forecast            ForecastConfig         `yaml:"forecast"`
+	// PriceFloor is the raw pool price, without VAT and transmission cost, at or below which
+	// charging is free: it starts at max current whatever the deadline or desired price.
+	PriceFloor *money.Price `yaml:"price-floor"`
+	// Currency is what prices are calculated and compared in, EUR when empty.
+	Currency money.Currency `yaml:"currency" validate:"oneof=EUR SEK NOK DKK"`
+	// Fx converts prices in other currencies.
+	Fx money.FxConfig `yaml:"fx"`
+	// Source of the pool prices: elering, the default, publishes the Baltic and Finnish zones,
+	// data-portal, the Nord Pool Data Portal, all Nordic and Baltic zones.
+	Source string `yaml:"source" validate:"oneof=elering data-portal"`
+	rates  money.Rates
 }
 
 type PriceStatus string
@@ -50,56 +117,294 @@ type PriceStatus string
 const (
 	PriceGood   PriceStatus = "PriceGood"
 	PriceTooBig             = "PriceTooBig"
+	PriceFree               = "PriceFree"
+)
+
+// Pool prices are EUR/MWh, as Elering publishes them and the Data Portal is asked for.
+const (
+	poolCurrency = money.EUR
+	poolUnit     = money.MWh
+)
+
+// priceTolerance is how much above the desired price a price is still good.
+const priceTolerance = 0.01
+
+const (
+	// defaultResolution is the length of a price interval when it is not known, the day-ahead market is in 15 minute slots.
+	defaultResolution = 15 * time.Minute
+	// maxResolution is the longest price interval, historical prices are hourly.
+	maxResolution = time.Hour
 )
 
 var (
 	errPricesFileDoesNotExist = errors.New("prices file does not exist")
 	errPriceNotFound          = errors.New("price not found")
+	errUnknownZone            = errors.New("unknown zone")
 )
 
+var httpClient = metrics.NewHttpClient("elering")
+
+// eleringUrl is the pool prices endpoint of Elering.
+var eleringUrl = "https://dashboard.elering.ee/api/nps/price"
+
+// Zone returns the normalized prices of the zone.
+func (prices Prices) Zone(zone string) (zonePrices []Price, err error) {
+	switch zoneName(zone) {
+	case "ee":
+		return Normalize(prices.Data.Ee), nil
+	case "fi":
+		return Normalize(prices.Data.Fi), nil
+	case "lv":
+		return Normalize(prices.Data.Lv), nil
+	case "lt":
+		return Normalize(prices.Data.Lt), nil
+	default:
+		return nil, fmt.Errorf("%s : %w", zone, errUnknownZone)
+	}
+}
+
+func zoneName(zone string) string {
+	if zone == "" {
+		return "lt"
+	}
+	return strings.ToLower(zone)
+}
+
 func GetPrice(s3svc *s3.S3, awsS3Bucket string, date time.Time, config NordPoolConfig) (price float64, err error) {
+	poolPrice, err := GetPoolPrice(s3svc, awsS3Bucket, date, config)
+	if err != nil {
+		return
+	}
+	return CalculatePrice(config, date, poolPrice)
+}
+
+// GetPoolPrice returns the raw Nord Pool price at date, EUR/MWh.
+func GetPoolPrice(s3svc *s3.S3, awsS3Bucket string, date time.Time, config NordPoolConfig) (poolPrice float64, err error) {
 	locationDate, err := locationDate(config, date)
 	if err != nil {
 		return
 	}
-	prices, err := getPrices(s3svc, awsS3Bucket, locationDate)
+	zonePrices, err := getZonePrices(s3svc, awsS3Bucket, config, locationDate)
+	if err != nil {
+		return
+	}
+	poolPrice, err = findPrice(zonePrices, locationDate)
 	if err != nil {
 		return
 	}
-	poolPrice, err := findPrice(prices.Data.Lt, locationDate)
-	price, err = calculatePrice(locationDate, poolPrice, config)
+	metrics.SetGauge("pool_price", "Current Nord Pool price, EUR/MWh.", poolPrice, "zone", zoneName(config.Zone))
 	return
 }
 
+// CalculatePrice adds VAT and transmission cost at date to the pool price, EUR/MWh, and returns the price per kWh
+// in the currency of the config.
+func CalculatePrice(config NordPoolConfig, date time.Time, poolPrice float64) (price float64, err error) {
+	locationDate, err := locationDate(config, date)
+	if err != nil {
+		return
+	}
+	return calculatePrice(locationDate, poolPrice, config)
+}
+
+// IsFree tells whether the pool price, EUR/MWh, is at or below the price floor.
+func (config NordPoolConfig) IsFree(poolPrice float64) bool {
+	if config.PriceFloor == nil {
+		return false
+	}
+	price, err := config.Value(money.New(poolPrice, poolCurrency, poolUnit))
+	if err != nil {
+		slog.Warn("Failed to compare with the price floor", "error", err)
+		return false
+	}
+	floor, err := config.Value(*config.PriceFloor)
+	if err != nil {
+		slog.Warn("Failed to compare with the price floor", "error", err)
+		return false
+	}
+	return price <= floor
+}
+
+// SourceOrDefault returns the source of the pool prices in lower case, elering when not set.
+func (config NordPoolConfig) SourceOrDefault() string {
+	if config.Source == "" {
+		return SourceElering
+	}
+	return strings.ToLower(config.Source)
+}
+
+func (config NordPoolConfig) CurrencyOrDefault() money.Currency {
+	if config.Currency == "" {
+		return money.EUR
+	}
+	return money.Currency(strings.ToUpper(string(config.Currency)))
+}
+
+// WithRates returns the config with the exchange rates read for the run.
+func (config NordPoolConfig) WithRates(rates money.Rates) NordPoolConfig {
+	config.rates = rates
+	return config
+}
+
+// Value is price in the currency of the config per kWh.
+func (config NordPoolConfig) Value(price money.Price) (float64, error) {
+	return price.Value(config.CurrencyOrDefault(), money.KWh, config.rates)
+}
+
 func GetMinPriceTill(s3svc *s3.S3, awsS3Bucket string, date time.Time, config NordPoolConfig) (price float64, err error) {
 	locationDate, err := locationDate(config, date)
 	if err != nil {
 		return
 	}
-	prices, err := getPrices(s3svc, awsS3Bucket, locationDate)
+	zonePrices, err := getZonePrices(s3svc, awsS3Bucket, config, locationDate)
+	if err != nil {
+		return
+	}
+	zonePrices, err = withForecast(cachedDays(s3svc, awsS3Bucket, config), config, zonePrices, locationDate)
+	if err != nil {
+		return
+	}
+	return findMinPrice(config, zonePrices, locationDate)
+}
+
+// GetDayPrices returns the zone prices of the whole day of date, from the cache or fetched from the source.
+func GetDayPrices(s3svc *s3.S3, awsS3Bucket string, date time.Time, config NordPoolConfig) (zonePrices []Price, err error) {
+	locationDate, err := locationDate(config, date)
 	if err != nil {
 		return
 	}
-	return findMinPrice(config, prices.Data.Lt, locationDate)
+	midnight := time.Date(locationDate.Year(), locationDate.Month(), locationDate.Day(), 0, 0, 0, 0, locationDate.Location())
+	return getZonePrices(s3svc, awsS3Bucket, config, midnight)
+}
+
+// PriceAt returns the final price, with VAT and transmission cost, of the slot containing date.
+func PriceAt(config NordPoolConfig, prices []Price, date time.Time) (price float64, err error) {
+	locationDate, err := locationDate(config, date)
+	if err != nil {
+		return
+	}
+	poolPrice, err := findPrice(prices, locationDate)
+	if err != nil {
+		return
+	}
+	return calculatePrice(locationDate, poolPrice, config)
+}
+
+// MinPriceTill is GetMinPriceTill for already loaded prices.
+func MinPriceTill(config NordPoolConfig, prices []Price, date time.Time) (price float64, err error) {
+	locationDate, err := locationDate(config, date)
+	if err != nil {
+		return
+	}
+	return findMinPrice(config, prices, locationDate)
 }
 
 func findMinPrice(config NordPoolConfig, prices []Price, locationDate time.Time) (price float64, err error) {
 	price = math.MaxFloat64
 	chargeTillHour := getChargeTillHour(config, locationDate)
 	for locationDate.Hour() != chargeTillHour {
-		var poolPrice float64
-		poolPrice, err = findPrice(prices, locationDate)
+		var poolPrice Price
+		poolPrice, err = lookupPrice(prices, locationDate)
 		if err != nil {
 			if errors.Is(err, errPriceNotFound) {
 				return price, nil
 			}
 			return
 		}
-		poolPrice, err = calculatePrice(locationDate, poolPrice, config)
-		if poolPrice < price {
-			price = poolPrice
+		if poolPrice.Forecast && !config.Forecast.Trust {
+			return price, nil
+		}
+		var slotPrice float64
+		slotPrice, err = calculatePrice(locationDate, poolPrice.Price, config)
+		if slotPrice < price {
+			price = slotPrice
+		}
+		locationDate = poolPrice.End().In(locationDate.Location())
+	}
+	return
+}
+
+// Window is a time range of consecutive slots, for example of good prices.
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Price is the average price of the window slots.
+	Price float64 `json:"price"`
+}
+
+// IsGood tells whether the price is good enough compared with the desired price.
+func IsGood(price float64, desiredPrice float64) bool {
+	return price-desiredPrice < priceTolerance
+}
+
+// GetCheapWindows returns the windows from date till the deadline where the price is good, as the flow decides slot by slot.
+func GetCheapWindows(s3svc *s3.S3, awsS3Bucket string, date time.Time, config NordPoolConfig, desiredPrice float64) (windows []Window, err error) {
+	locationDate, err := locationDate(config, date)
+	if err != nil {
+		return
+	}
+	zonePrices, err := getZonePrices(s3svc, awsS3Bucket, config, locationDate)
+	if err != nil {
+		return
+	}
+	zonePrices, err = withForecast(cachedDays(s3svc, awsS3Bucket, config), config, zonePrices, locationDate)
+	if err != nil {
+		return
+	}
+	return CheapWindows(config, zonePrices, date, desiredPrice)
+}
+
+// CheapWindows is GetCheapWindows for already loaded prices. It stops at the first missing or untrusted forecast slot.
+func CheapWindows(config NordPoolConfig, prices []Price, date time.Time, desiredPrice float64) (windows []Window, err error) {
+	from, err := locationDate(config, date)
+	if err != nil {
+		return
+	}
+	deadline, err := Deadline(config, date)
+	if err != nil {
+		return
+	}
+	// slots are the price intervals, hourly or 15 minutes, as in findMinPrice
+	for date := from; date.Before(deadline); {
+		var poolPrice Price
+		poolPrice, err = lookupPrice(prices, date)
+		if errors.Is(err, errPriceNotFound) || poolPrice.Forecast && !config.Forecast.Trust {
+			return windows, nil
+		}
+		if err != nil {
+			return
+		}
+		start, end := poolPrice.Start().In(from.Location()), poolPrice.End().In(from.Location())
+		date = end
+		var price float64
+		price, err = calculatePrice(start, poolPrice.Price, config)
+		if err != nil {
+			return
+		}
+		if !IsGood(price, desiredPrice) && !config.IsFree(poolPrice.Price) {
+			continue
+		}
+		if len(windows) > 0 && windows[len(windows)-1].End.Equal(start) {
+			window := &windows[len(windows)-1]
+			length, slotLength := window.End.Sub(window.Start).Hours(), end.Sub(start).Hours()
+			window.Price = (window.Price*length + price*slotLength) / (length + slotLength)
+			window.End = end
+		} else {
+			windows = append(windows, Window{Start: start, End: end, Price: price})
 		}
-		locationDate = locationDate.Add(15 * time.Minute)
+	}
+	return
+}
+
+// Deadline returns the next time charging has to be finished by, in the configured timezone.
+func Deadline(config NordPoolConfig, date time.Time) (deadline time.Time, err error) {
+	locationDate, err := locationDate(config, date)
+	if err != nil {
+		return
+	}
+	chargeTillHour := getChargeTillHour(config, locationDate)
+	deadline = time.Date(locationDate.Year(), locationDate.Month(), locationDate.Day(), chargeTillHour, 0, 0, 0, locationDate.Location())
+	if !deadline.After(locationDate) {
+		deadline = deadline.AddDate(0, 0, 1)
 	}
 	return
 }
@@ -112,6 +417,18 @@ func getChargeTillHour(config NordPoolConfig, date time.Time) int {
 	}
 }
 
+// getZonePrices returns the normalized zone prices of a day from locationDate, from the cache or fetched from the source.
+func getZonePrices(s3svc *s3.S3, awsS3Bucket string, config NordPoolConfig, locationDate time.Time) (zonePrices []Price, err error) {
+	if config.SourceOrDefault() == SourceDataPortal {
+		return getDataPortalPrices(s3svc, awsS3Bucket, config.Zone, locationDate)
+	}
+	prices, err := getPrices(s3svc, awsS3Bucket, locationDate)
+	if err != nil {
+		return
+	}
+	return prices.Zone(config.Zone)
+}
+
 func getPrices(s3svc *s3.S3, awsS3Bucket string, locationDate time.Time) (prices Prices, err error) {
 	prices, err = readPrices(s3svc, awsS3Bucket, locationDate)
 	if err != nil {
@@ -136,6 +453,47 @@ func locationDate(config NordPoolConfig, date time.Time) (locationDate time.Time
 }
 
 func calculatePrice(date time.Time, poolPrice float64, config NordPoolConfig) (price float64, err error) {
+	energy, transmission, err := priceParts(date, poolPrice, config)
+	if err != nil {
+		return
+	}
+	price = energy*(1+config.Vat) + transmission
+	return
+}
+
+// Breakdown is the price of a slot per kWh in the currency of the config with its parts, VAT is on energy only.
+type Breakdown struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// PoolPrice is as published, EUR/MWh.
+	PoolPrice    float64 `json:"poolPrice"`
+	Energy       float64 `json:"energy"`
+	Vat          float64 `json:"vat"`
+	Transmission float64 `json:"transmission"`
+	Price        float64 `json:"price"`
+	Free         bool    `json:"free,omitempty"`
+}
+
+// PriceBreakdown splits the final price of the slot into energy, VAT and transmission cost.
+func PriceBreakdown(config NordPoolConfig, price Price) (breakdown Breakdown, err error) {
+	energy, transmission, err := priceParts(price.Start(), price.Price, config)
+	if err != nil {
+		return
+	}
+	return Breakdown{
+		Start:        price.Start(),
+		End:          price.End(),
+		PoolPrice:    price.Price,
+		Energy:       energy,
+		Vat:          energy * config.Vat,
+		Transmission: transmission,
+		Price:        energy*(1+config.Vat) + transmission,
+		Free:         config.IsFree(price.Price),
+	}, nil
+}
+
+// priceParts returns the pool price and the transmission cost at date, per kWh in the currency of the config.
+func priceParts(date time.Time, poolPrice float64, config NordPoolConfig) (energy float64, transmission float64, err error) {
 	costConfig := config.TransmissionCost
 	location, err := time.LoadLocation(costConfig.Timezone)
 	if err != nil {
@@ -144,28 +502,38 @@ func calculatePrice(date time.Time, poolPrice float64, config NordPoolConfig) (p
 	transmissionDate := date.In(location)
 	workday := transmissionDate.Weekday() != time.Saturday && transmissionDate.Weekday() != time.Sunday
 
+	cost := costConfig.Night
 	if workday && transmissionDate.Hour() >= costConfig.DayStartsAt && transmissionDate.Hour() < costConfig.NightStartsAt {
-		price = (poolPrice/1000)*(1+config.Vat) + costConfig.Day
-	} else {
-		price = (poolPrice/1000)*(1+config.Vat) + costConfig.Night
+		cost = costConfig.Day
 	}
+	energy, err = config.Value(money.New(poolPrice, poolCurrency, poolUnit))
+	if err != nil {
+		return
+	}
+	transmission, err = config.Value(cost)
 	return
 }
 
 func findPrice(prices []Price, date time.Time) (price float64, err error) {
-	timestamp := date.Truncate(15 * time.Minute).Unix()
-	log.Printf("Looking for price at %d, date %s", timestamp, date)
+	poolPrice, err := lookupPrice(prices, date)
+	return poolPrice.Price, err
+}
+
+// lookupPrice returns the price of the interval containing date.
+func lookupPrice(prices []Price, date time.Time) (price Price, err error) {
+	slog.Debug("Looking for price", "date", date)
 	for _, p := range prices {
-		if p.Timestamp == timestamp {
-			price = p.Price
-			return
+		if p.Contains(date) {
+			return p, nil
 		}
 	}
-	return price, fmt.Errorf("%d : %w", timestamp, errPriceNotFound)
+	return price, fmt.Errorf("%d : %w", date.Unix(), errPriceNotFound)
 }
 
+// fetchDates fetches the prices of a day from date. Only complete days are cached, a day fetched before it is
+// published is fetched again on the next run.
 func fetchDates(s3svc *s3.S3, awsS3Bucket string, date time.Time) (prices Prices, err error) {
-	req, err := http.NewRequest("GET", "https://dashboard.elering.ee/api/nps/price", nil)
+	req, err := http.NewRequest("GET", eleringUrl, nil)
 	if err != nil {
 		return
 	}
@@ -173,32 +541,52 @@ func fetchDates(s3svc *s3.S3, awsS3Bucket string, date time.Time) (prices Prices
 	trunc := time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), 0, 0, 0, date.Location())
 	q.Add("start", trunc.Format(time.RFC3339))
 	q.Add("end", trunc.AddDate(0, 0, 1).Format(time.RFC3339))
-	log.Printf("Fetching prices from %s to %s", q.Get("start"), q.Get("end"))
+	slog.Info("Fetching prices", "start", q.Get("start"), "end", q.Get("end"))
 	req.URL.RawQuery = q.Encode()
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
 	pricesBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
-	err = writeDates(s3svc, awsS3Bucket, date, pricesBytes)
-	if err != nil {
-		return
+	if resp.StatusCode != http.StatusOK {
+		return prices, fmt.Errorf("%s %s : %s", eleringUrl, resp.Status, pricesBytes)
 	}
 	err = json.Unmarshal(pricesBytes, &prices)
 	if err != nil {
 		return
 	}
+	if !prices.complete(trunc.AddDate(0, 0, 1)) {
+		slog.Debug("Prices not published yet, not caching", "start", q.Get("start"))
+		return
+	}
+	err = writeDates(s3svc, awsS3Bucket, date, pricesBytes)
 	return
 }
 
+// complete tells whether the prices of every zone reach end.
+func (prices Prices) complete(end time.Time) bool {
+	for _, zone := range Zones(SourceElering) {
+		zonePrices, _ := prices.Zone(zone)
+		if len(zonePrices) == 0 || zonePrices[len(zonePrices)-1].End().Before(end) {
+			return false
+		}
+	}
+	return true
+}
+
 func writeDates(s3svc *s3.S3, awsS3Bucket string, date time.Time, prices []byte) (err error) {
+	return writeCache(s3svc, awsS3Bucket, pricesFileName(date), prices)
+}
+
+func writeCache(s3svc *s3.S3, awsS3Bucket string, fileName string, prices []byte) (err error) {
 	_, err = s3svc.PutObject(&s3.PutObjectInput{
 		Body:   bytes.NewReader(prices),
 		Bucket: &awsS3Bucket,
-		Key:    aws.String(pricesFileName(date)),
+		Key:    aws.String(fileName),
 	})
 	return err
 }
@@ -208,20 +596,25 @@ func pricesFileName(date time.Time) string {
 }
 
 func readPrices(s3svc *s3.S3, awsS3Bucket string, date time.Time) (prices Prices, err error) {
-	fileName := pricesFileName(date)
-	log.Printf("Reading prices from %s", fileName)
+	pricesBytes, err := readCache(s3svc, awsS3Bucket, pricesFileName(date))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(pricesBytes, &prices)
+	return
+}
+
+func readCache(s3svc *s3.S3, awsS3Bucket string, fileName string) (pricesBytes []byte, err error) {
+	slog.Debug("Reading prices", "file", fileName)
 	input := &s3.GetObjectInput{Bucket: aws.String(awsS3Bucket),
 		Key: &fileName,
 	}
 	output, err := s3svc.GetObject(input)
 	if err != nil {
-		return prices, fmt.Errorf("%s - %w", fileName, errPricesFileDoesNotExist)
+		metrics.AddCounter("cache_misses_total", "Cache misses per cached file kind.", 1, "file", "prices")
+		return nil, fmt.Errorf("%s - %w", fileName, errPricesFileDoesNotExist)
 	}
+	metrics.AddCounter("cache_hits_total", "Cache hits per cached file kind.", 1, "file", "prices")
 	defer output.Body.Close()
-	pricesBytes, err := io.ReadAll(output.Body)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(pricesBytes, &prices)
-	return
+	return io.ReadAll(output.Body)
 }
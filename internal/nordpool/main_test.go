@@ -1,17 +1,26 @@
 package nordpool
 
 import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
+	"wallbox_nord_pool/internal/money"
 )
 
 func TestCalculatePrice(t *testing.T) {
 	config := NordPoolConfig{
-		MaxPrice:         0,
+		MaxPrice:         money.PerKWh(0),
 		Vat:              0,
 		Timezone:         "Europe/Vilnius",
-		TransmissionCost: TransmissionCostConfig{Day: 0.1, Night: 0.05, DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
+		TransmissionCost: TransmissionCostConfig{Day: money.PerKWh(0.1), Night: money.PerKWh(0.05), DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
 	}
 	location, _ := time.LoadLocation(config.Timezone)
 	tests := []struct {
@@ -36,14 +45,39 @@ func TestCalculatePrice(t *testing.T) {
 	}
 }
 
+func TestPriceBreakdown(t *testing.T) {
+	floor := money.PerKWh(0.05)
+	config := NordPoolConfig{
+		Vat:              0.2,
+		PriceFloor:       &floor,
+		TransmissionCost: TransmissionCostConfig{Day: money.PerKWh(0.1), Night: money.New(5, "EUR", money.MWh), DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
+	}
+	// 2023-08-30 is a Wednesday, 10:00 at GMT+2 is day
+	price := Price{Timestamp: time.Date(2023, 8, 30, 8, 0, 0, 0, time.UTC).Unix(), Duration: time.Hour, Price: 50}
+	got, err := PriceBreakdown(config, price)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	want := Breakdown{Start: price.Start(), End: price.End(), PoolPrice: 50, Energy: 0.05, Vat: 0.01, Transmission: 0.1, Price: 0.16, Free: true}
+	if math.Abs(got.Price-want.Price) > 1e-9 || math.Abs(got.Vat-want.Vat) > 1e-9 || got.Energy != want.Energy ||
+		got.Transmission != want.Transmission || !got.End.Equal(want.End) || got.Free != want.Free {
+		t.Errorf("Got breakdown %+v, wanted %+v", got, want)
+	}
+	price.Timestamp = time.Date(2023, 8, 30, 22, 0, 0, 0, time.UTC).Unix()
+	got, err = PriceBreakdown(config, price)
+	if err != nil || math.Abs(got.Transmission-0.005) > 1e-9 {
+		t.Errorf("Got night transmission %f, wanted %f", got.Transmission, 0.005)
+	}
+}
+
 func TestFindMinPriceNight(t *testing.T) {
 	config := NordPoolConfig{
-		MaxPrice:            0,
+		MaxPrice:            money.PerKWh(0),
 		ChargeTillHourDay:   18,
 		ChargeTillHourNight: 8,
 		Vat:                 0,
 		Timezone:            "Europe/Vilnius",
-		TransmissionCost:    TransmissionCostConfig{Day: 0.1, Night: 0.05, DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
+		TransmissionCost:    TransmissionCostConfig{Day: money.PerKWh(0.1), Night: money.PerKWh(0.05), DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
 	}
 	location, _ := time.LoadLocation(config.Timezone)
 	tests := []struct {
@@ -57,6 +91,7 @@ func TestFindMinPriceNight(t *testing.T) {
 		{name: "Second lower", prices: []Price{{Timestamp: 1690840800, Price: 200}, {Timestamp: 1690841700, Price: 100}}, wantPrice: 0.15},
 		{name: "Three prices", prices: []Price{{Timestamp: 1690840800, Price: 200}, {Timestamp: 1690841700, Price: 100}, {Timestamp: 1690842600, Price: 200}}, wantPrice: 0.15},
 		{name: "Multiple 15-min intervals", prices: []Price{{Timestamp: 1690840800, Price: 300}, {Timestamp: 1690841700, Price: 200}, {Timestamp: 1690842600, Price: 100}, {Timestamp: 1690843500, Price: 150}}, wantPrice: 0.15},
+		{name: "Hourly intervals", prices: Normalize([]Price{{Timestamp: 1690840800, Price: 300}, {Timestamp: 1690844400, Price: 100}, {Timestamp: 1690848000, Price: 200}}), wantPrice: 0.15},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -73,12 +108,12 @@ func TestFindMinPriceNight(t *testing.T) {
 
 func TestFindMinPriceDay(t *testing.T) {
 	config := NordPoolConfig{
-		MaxPrice:            0,
+		MaxPrice:            money.PerKWh(0),
 		ChargeTillHourDay:   18,
 		ChargeTillHourNight: 8,
 		Vat:                 0,
 		Timezone:            "Europe/Vilnius",
-		TransmissionCost:    TransmissionCostConfig{Day: 0.1, Night: 0.05, DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
+		TransmissionCost:    TransmissionCostConfig{Day: money.PerKWh(0.1), Night: money.PerKWh(0.05), DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
 	}
 	location, _ := time.LoadLocation(config.Timezone)
 	tests := []struct {
@@ -102,3 +137,268 @@ func TestFindMinPriceDay(t *testing.T) {
 		})
 	}
 }
+
+func TestPricesZone(t *testing.T) {
+	prices := Prices{}
+	prices.Data.Lt = []Price{{Timestamp: 1, Price: 10}}
+	prices.Data.Fi = []Price{{Timestamp: 1, Price: 20}}
+	tests := []struct {
+		name      string
+		zone      string
+		wantPrice float64
+		wantErr   bool
+	}{
+		{name: "Default", zone: "", wantPrice: 10},
+		{name: "Lt", zone: "lt", wantPrice: 10},
+		{name: "Fi upper case", zone: "FI", wantPrice: 20},
+		{name: "Unknown", zone: "se3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zonePrices, err := prices.Zone(tt.zone)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, wanted error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && zonePrices[0].Price != tt.wantPrice {
+				t.Errorf("Got price %f, wanted %f", zonePrices[0].Price, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	config := NordPoolConfig{ChargeTillHourDay: 18, ChargeTillHourNight: 8, Timezone: "Europe/Vilnius"}
+	location, _ := time.LoadLocation(config.Timezone)
+	tests := []struct {
+		name         string
+		date         time.Time
+		wantDeadline time.Time
+	}{
+		{name: "Day", date: time.Date(2023, 8, 1, 12, 0, 0, 0, location), wantDeadline: time.Date(2023, 8, 1, 18, 0, 0, 0, location)},
+		{name: "Evening", date: time.Date(2023, 8, 1, 20, 0, 0, 0, location), wantDeadline: time.Date(2023, 8, 2, 8, 0, 0, 0, location)},
+		{name: "After midnight", date: time.Date(2023, 8, 2, 1, 0, 0, 0, location), wantDeadline: time.Date(2023, 8, 2, 8, 0, 0, 0, location)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadline, err := Deadline(config, tt.date)
+			if err != nil {
+				t.Errorf("Got Error %s", err)
+			}
+			if !deadline.Equal(tt.wantDeadline) {
+				t.Errorf("Got deadline %s, wanted %s", deadline, tt.wantDeadline)
+			}
+		})
+	}
+}
+
+func TestIsFree(t *testing.T) {
+	floor := money.PerKWh(0)
+	cents := money.New(1, money.EUR, money.KWh).In(money.MWh)
+	crowns := money.New(0.115, money.SEK, money.KWh)
+	tests := []struct {
+		name      string
+		floor     *money.Price
+		currency  money.Currency
+		poolPrice float64
+		want      bool
+	}{
+		{name: "No floor", poolPrice: -10, want: false},
+		{name: "Negative", floor: &floor, poolPrice: -3.5, want: true},
+		{name: "At floor", floor: &floor, poolPrice: 0, want: true},
+		{name: "Above floor", floor: &floor, poolPrice: 0.01, want: false},
+		{name: "Floor per MWh", floor: &cents, poolPrice: 1000, want: true},
+		{name: "Floor in SEK", floor: &crowns, poolPrice: 10, want: true},
+		{name: "Above floor in SEK", floor: &crowns, currency: money.SEK, poolPrice: 10.1, want: false},
+		{name: "No rate", floor: &crowns, currency: money.NOK, poolPrice: -10, want: false},
+	}
+	rates := money.FixedRates{Rates: map[money.Currency]float64{money.SEK: 11.5}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NordPoolConfig{PriceFloor: tt.floor, Currency: tt.currency}.WithRates(rates)
+			if got := config.IsFree(tt.poolPrice); got != tt.want {
+				t.Errorf("IsFree() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheapWindows(t *testing.T) {
+	config := NordPoolConfig{
+		ChargeTillHourDay:   18,
+		ChargeTillHourNight: 8,
+		Timezone:            "Europe/Vilnius",
+		TransmissionCost:    TransmissionCostConfig{Day: money.PerKWh(0.1), Night: money.PerKWh(0.05), DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
+	}
+	location, _ := time.LoadLocation(config.Timezone)
+	date := time.Date(2023, 8, 1, 1, 5, 0, 0, location)
+	slot := func(minutes int) time.Time {
+		return time.Date(2023, 8, 1, 1, minutes, 0, 0, location)
+	}
+	prices := []Price{{Timestamp: 1690840800, Price: 100}, {Timestamp: 1690841700, Price: 80}, {Timestamp: 1690842600, Price: 300},
+		{Timestamp: 1690843500, Price: 100, Forecast: true}}
+	tests := []struct {
+		name        string
+		trust       bool
+		wantWindows []Window
+	}{
+		{name: "Stops at forecast", wantWindows: []Window{{slot(0), slot(30), 0.14}}},
+		{name: "Trusted forecast", trust: true, wantWindows: []Window{{slot(0), slot(30), 0.14}, {slot(45), slot(60), 0.15}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Forecast.Trust = tt.trust
+			windows, err := CheapWindows(config, prices, date, 0.15)
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if len(windows) != len(tt.wantWindows) {
+				t.Fatalf("Got windows %v, wanted %v", windows, tt.wantWindows)
+			}
+			for i, window := range windows {
+				if !window.Start.Equal(tt.wantWindows[i].Start) || !window.End.Equal(tt.wantWindows[i].End) ||
+					math.Abs(window.Price-tt.wantWindows[i].Price) > 1e-9 {
+					t.Errorf("Got window %v, wanted %v", window, tt.wantWindows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheapWindowsHourly(t *testing.T) {
+	config := NordPoolConfig{
+		ChargeTillHourDay:   18,
+		ChargeTillHourNight: 8,
+		Timezone:            "Europe/Vilnius",
+		TransmissionCost:    TransmissionCostConfig{Day: money.PerKWh(0.1), Night: money.PerKWh(0.05), DayStartsAt: 7, NightStartsAt: 23, Timezone: "Etc/GMT-2"},
+	}
+	location, _ := time.LoadLocation(config.Timezone)
+	at := func(hour int, minutes int) time.Time {
+		return time.Date(2023, 8, 1, hour, minutes, 0, 0, location)
+	}
+	// hourly prices, then 15 minute ones
+	prices := Normalize([]Price{{Timestamp: at(1, 0).Unix(), Price: 100}, {Timestamp: at(2, 0).Unix(), Price: 80},
+		{Timestamp: at(2, 15).Unix(), Price: 300, Duration: 15 * time.Minute}})
+	hourly, _ := calculatePrice(at(1, 0), 100, config)
+	quarter, _ := calculatePrice(at(2, 0), 80, config)
+	windows, err := CheapWindows(config, prices, at(1, 20), 0.15)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	want := Window{Start: at(1, 0), End: at(2, 15), Price: (hourly*4 + quarter) / 5}
+	if len(windows) != 1 || !windows[0].Start.Equal(want.Start) || !windows[0].End.Equal(want.End) || math.Abs(windows[0].Price-want.Price) > 1e-9 {
+		t.Errorf("Got windows %v, wanted %v", windows, want)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Vilnius")
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2025, 9, 30, hour, minute, 0, 0, location)
+	}
+	// hourly prices till the day-ahead market switchover at midnight, then 15 minute ones, unsorted and with a gap
+	prices := Normalize([]Price{
+		{Timestamp: at(24, 0).Unix(), Price: 40},
+		{Timestamp: at(22, 0).Unix(), Price: 20},
+		{Timestamp: at(23, 0).Unix(), Price: 30},
+		{Timestamp: at(24, 15).Unix(), Price: 50},
+		{Timestamp: at(27, 0).Unix(), Price: 60},
+	})
+	tests := []struct {
+		name      string
+		date      time.Time
+		wantPrice float64
+		wantErr   bool
+	}{
+		{name: "Hour start", date: at(22, 0), wantPrice: 20},
+		{name: "Inside hour", date: at(23, 45), wantPrice: 30},
+		{name: "First quarter", date: at(24, 10), wantPrice: 40},
+		{name: "Quarter before gap", date: at(24, 20), wantPrice: 50},
+		{name: "Gap", date: at(25, 0), wantErr: true},
+		{name: "Last", date: at(27, 14), wantPrice: 60},
+		{name: "After last", date: at(27, 15), wantErr: true},
+		{name: "Before first", date: at(21, 59), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := lookupPrice(prices, tt.date)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, wanted error %t", err, tt.wantErr)
+			}
+			if p.Price != tt.wantPrice {
+				t.Errorf("Got price %f, wanted %f", p.Price, tt.wantPrice)
+			}
+		})
+	}
+}
+
+// testS3 returns an S3 client of a fake bucket that records the keys written.
+func testS3(t *testing.T) (svc *s3.S3, written func() []string) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			keys = append(keys, r.URL.Path)
+			mu.Unlock()
+		}
+	}))
+	t.Cleanup(server.Close)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("eu-north-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	return s3.New(sess), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return keys
+	}
+}
+
+func TestFetchDatesCache(t *testing.T) {
+	location, _ := time.LoadLocation("Europe/Vilnius")
+	date := time.Date(2024, 3, 5, 14, 0, 0, 0, location)
+	day := func(hours int) (prices Prices) {
+		prices.Success = true
+		for hour := 0; hour < hours; hour++ {
+			price := Price{Timestamp: date.Add(time.Duration(hour) * time.Hour).Unix(), Price: 50}
+			prices.Data.Ee = append(prices.Data.Ee, price)
+			prices.Data.Fi = append(prices.Data.Fi, price)
+			prices.Data.Lv = append(prices.Data.Lv, price)
+			prices.Data.Lt = append(prices.Data.Lt, price)
+		}
+		return
+	}
+	tests := []struct {
+		name       string
+		prices     Prices
+		wantCached bool
+	}{
+		{name: "Empty", prices: day(0)},
+		{name: "Not published till the end", prices: day(10)},
+		{name: "Complete", prices: day(24), wantCached: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(tt.prices)
+			}))
+			defer server.Close()
+			defaultUrl := eleringUrl
+			eleringUrl = server.URL
+			defer func() { eleringUrl = defaultUrl }()
+			svc, written := testS3(t)
+			prices, err := fetchDates(svc, "bucket", date)
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if len(prices.Data.Lt) != len(tt.prices.Data.Lt) {
+				t.Errorf("Got %d prices, wanted %d", len(prices.Data.Lt), len(tt.prices.Data.Lt))
+			}
+			if cached := len(written()) > 0; cached != tt.wantCached {
+				t.Errorf("Got cached %t, wanted %t: %v", cached, tt.wantCached, written())
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+type Event string
+
+const (
+	EventActionChanged      Event = "action-changed"
+	EventRunError           Event = "run-error"
+	EventDeadlineAtRisk     Event = "deadline-at-risk"
+	EventTokenRefreshFailed Event = "token-refresh-failed"
+	EventPriceFree          Event = "price-free"
+)
+
+const (
+	stateFile       = "notify_state.json"
+	defaultDedup    = 6 * time.Hour
+	defaultDeadline = 2 * time.Hour
+)
+
+type Config struct {
+	// Events to notify about, all of them when empty.
+	Events []Event `yaml:"events" validate:"oneof=action-changed run-error deadline-at-risk token-refresh-failed price-free"`
+	// Dedup is how long a message of the same event, charger and kind is not sent again.
+	Dedup time.Duration `yaml:"dedup" validate:"min=0"`
+	// DeadlineWarning is how long before the deadline a waiting charger is at risk.
+	DeadlineWarning time.Duration   `yaml:"deadline-warning" validate:"min=0"`
+	Webhook         *WebhookConfig  `yaml:"webhook"`
+	Telegram        *TelegramConfig `yaml:"telegram"`
+	Email           *EmailConfig    `yaml:"email"`
+	Ntfy            *NtfyConfig     `yaml:"ntfy"`
+}
+
+type Message struct {
+	Event   Event  `json:"event"`
+	Charger string `json:"charger,omitempty"`
+	// Kind tells messages of the same event and charger apart when repeating, the text may change between runs.
+	Kind string    `json:"kind,omitempty"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+type Sink interface {
+	Send(message Message) error
+}
+
+// State is kept between runs to find action changes and not to repeat messages.
+type State struct {
+	Actions map[string]string `json:"actions"`
+	Sent    map[string]int64  `json:"sent"`
+}
+
+type Notifier struct {
+	config      Config
+	sinks       []Sink
+	state       State
+	s3svc       *s3.S3
+	awsS3Bucket string
+}
+
+func NewNotifier(config Config, s3svc *s3.S3, awsS3Bucket string) (notifier *Notifier, err error) {
+	notifier = &Notifier{config: config, sinks: config.sinks(), state: newState(), s3svc: s3svc, awsS3Bucket: awsS3Bucket}
+	if len(notifier.sinks) == 0 {
+		return
+	}
+	notifier.state, err = readState(s3svc, awsS3Bucket)
+	return
+}
+
+func (config Config) sinks() (sinks []Sink) {
+	if config.Webhook != nil {
+		sinks = append(sinks, *config.Webhook)
+	}
+	if config.Telegram != nil {
+		sinks = append(sinks, *config.Telegram)
+	}
+	if config.Email != nil {
+		sinks = append(sinks, *config.Email)
+	}
+	if config.Ntfy != nil {
+		sinks = append(sinks, *config.Ntfy)
+	}
+	return
+}
+
+func (config Config) DeadlineWarningOrDefault() time.Duration {
+	if config.DeadlineWarning > 0 {
+		return config.DeadlineWarning
+	}
+	return defaultDeadline
+}
+
+// ActionChanged notifies when a charger performs a different action than the last performed one.
+func (notifier *Notifier) ActionChanged(charger string, action string) {
+	previous, ok := notifier.state.Actions[charger]
+	notifier.state.Actions[charger] = action
+	if ok && previous == action {
+		return
+	}
+	notifier.Notify(Message{Event: EventActionChanged, Charger: charger, Kind: action, Text: fmt.Sprintf("Charger %s: %s", charger, action)})
+}
+
+// Notify sends the message to all sinks unless the event is filtered out or the message was sent recently.
+func (notifier *Notifier) Notify(message Message) {
+	if len(notifier.sinks) == 0 || !notifier.config.wants(message.Event) {
+		return
+	}
+	if message.Time.IsZero() {
+		message.Time = time.Now()
+	}
+	if !notifier.state.shouldSend(message, notifier.config.dedup()) {
+		slog.Debug("Skipping repeated notification", "event", message.Event, "charger", message.Charger)
+		return
+	}
+	for _, sink := range notifier.sinks {
+		err := sink.Send(message)
+		if err != nil {
+			slog.Warn("Failed to send notification", "event", message.Event, "charger", message.Charger, "error", err)
+		}
+	}
+}
+
+// Close saves the state for the next run.
+func (notifier *Notifier) Close() error {
+	if len(notifier.sinks) == 0 {
+		return nil
+	}
+	notifier.state.expire(time.Now(), notifier.config.dedup())
+	return writeState(notifier.s3svc, notifier.awsS3Bucket, notifier.state)
+}
+
+func (config Config) wants(event Event) bool {
+	if len(config.Events) == 0 {
+		return true
+	}
+	for _, e := range config.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (config Config) dedup() time.Duration {
+	if config.Dedup > 0 {
+		return config.Dedup
+	}
+	return defaultDedup
+}
+
+func newState() State {
+	return State{Actions: map[string]string{}, Sent: map[string]int64{}}
+}
+
+func (state State) shouldSend(message Message, dedup time.Duration) bool {
+	key := strings.Join([]string{string(message.Event), message.Charger, message.Kind}, "|")
+	sent, ok := state.Sent[key]
+	if ok && message.Time.Sub(time.Unix(sent, 0)) < dedup {
+		return false
+	}
+	state.Sent[key] = message.Time.Unix()
+	return true
+}
+
+func (state State) expire(now time.Time, dedup time.Duration) {
+	for key, sent := range state.Sent {
+		if now.Sub(time.Unix(sent, 0)) >= dedup {
+			delete(state.Sent, key)
+		}
+	}
+}
+
+func readState(s3svc *s3.S3, awsS3Bucket string) (state State, err error) {
+	state = newState()
+	input := &s3.GetObjectInput{Bucket: aws.String(awsS3Bucket),
+		Key: aws.String(stateFile),
+	}
+	output, err := s3svc.GetObject(input)
+	if err != nil {
+		// first run, nothing was sent yet
+		return state, nil
+	}
+	defer output.Body.Close()
+	stateBytes, err := io.ReadAll(output.Body)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(stateBytes, &state)
+	if state.Actions == nil {
+		state.Actions = map[string]string{}
+	}
+	if state.Sent == nil {
+		state.Sent = map[string]int64{}
+	}
+	return
+}
+
+func writeState(s3svc *s3.S3, awsS3Bucket string, state State) (err error) {
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	_, err = s3svc.PutObject(&s3.PutObjectInput{
+		Body:   bytes.NewReader(stateBytes),
+		Bucket: &awsS3Bucket,
+		Key:    aws.String(stateFile),
+	})
+	return err
+}
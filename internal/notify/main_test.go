@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	messages *[]Message
+}
+
+func (sink recordingSink) Send(message Message) error {
+	*sink.messages = append(*sink.messages, message)
+	return nil
+}
+
+func TestNotify(t *testing.T) {
+	now := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		config   Config
+		messages []Message
+		wantSent int
+	}{
+		{
+			name:     "Different chargers",
+			messages: []Message{{Event: EventRunError, Charger: "a", Text: "a", Time: now}, {Event: EventRunError, Charger: "b", Text: "a", Time: now}},
+			wantSent: 2,
+		},
+		{
+			name:     "Different kinds",
+			messages: []Message{{Event: EventActionChanged, Kind: "pause", Time: now}, {Event: EventActionChanged, Kind: "resume", Time: now}},
+			wantSent: 2,
+		},
+		{
+			name:     "Changed text",
+			messages: []Message{{Event: EventRunError, Text: "timeout after 10s", Time: now}, {Event: EventRunError, Text: "timeout after 12s", Time: now.Add(15 * time.Minute)}},
+			wantSent: 1,
+		},
+		{
+			name:     "Repeated within dedup",
+			messages: []Message{{Event: EventRunError, Text: "a", Time: now}, {Event: EventRunError, Text: "a", Time: now.Add(15 * time.Minute)}},
+			wantSent: 1,
+		},
+		{
+			name:     "Repeated after dedup",
+			config:   Config{Dedup: time.Hour},
+			messages: []Message{{Event: EventRunError, Text: "a", Time: now}, {Event: EventRunError, Text: "a", Time: now.Add(time.Hour)}},
+			wantSent: 2,
+		},
+		{
+			name:     "Filtered event",
+			config:   Config{Events: []Event{EventActionChanged}},
+			messages: []Message{{Event: EventRunError, Text: "a", Time: now}},
+			wantSent: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []Message
+			notifier := &Notifier{config: tt.config, sinks: []Sink{recordingSink{&sent}}, state: newState()}
+			for _, message := range tt.messages {
+				notifier.Notify(message)
+			}
+			if len(sent) != tt.wantSent {
+				t.Errorf("Sent %d messages, wanted %d", len(sent), tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestActionChanged(t *testing.T) {
+	var sent []Message
+	notifier := &Notifier{sinks: []Sink{recordingSink{&sent}}, state: newState()}
+	notifier.state.Actions["garage"] = "pause"
+	for _, action := range []string{"pause", "resume", "resume", "pause"} {
+		notifier.ActionChanged("garage", action)
+	}
+	if len(sent) != 2 {
+		t.Errorf("Sent %d messages, wanted 2", len(sent))
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	var got Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+	webhook := WebhookConfig{Url: server.URL, Headers: map[string]string{"X-Token": "secret"}}
+	err := webhook.Send(Message{Event: EventRunError, Charger: "garage", Text: "failed"})
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	if got.Event != EventRunError || got.Text != "failed" {
+		t.Errorf("Got message %v", got)
+	}
+}
+
+func TestTelegramSendRedactsToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	defaultUrl := telegramUrl
+	telegramUrl = server.URL
+	defer func() { telegramUrl = defaultUrl }()
+	err := TelegramConfig{Token: "123:secret", ChatId: "1"}.Send(Message{Event: EventRunError, Text: "failed"})
+	if err == nil {
+		t.Fatalf("Got no error from a closed server")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Got the token in error %s", err)
+	}
+}
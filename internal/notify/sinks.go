@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+)
+
+var telegramUrl = "https://api.telegram.org"
+
+// WebhookConfig posts the message as JSON to URL.
+type WebhookConfig struct {
+	Url string `yaml:"url" validate:"required"`
+	// Headers values may be secret references, for example env:HOOK_TOKEN.
+	Headers map[string]string `yaml:"headers" secret:"true"`
+}
+
+type TelegramConfig struct {
+	Token  string `yaml:"token" validate:"required" secret:"true"`
+	ChatId string `yaml:"chat-id" validate:"required"`
+}
+
+type EmailConfig struct {
+	Host     string   `yaml:"host" validate:"required"`
+	Port     int      `yaml:"port" validate:"required,min=1,max=65535"`
+	Username string   `yaml:"username" secret:"true"`
+	Password string   `yaml:"password" secret:"true"`
+	From     string   `yaml:"from" validate:"required"`
+	To       []string `yaml:"to" validate:"required"`
+}
+
+type NtfyConfig struct {
+	Server string `yaml:"server"`
+	Topic  string `yaml:"topic" validate:"required"`
+	Token  string `yaml:"token" secret:"true"`
+}
+
+func (webhook WebhookConfig) Send(message Message) (err error) {
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", webhook.Url, bytes.NewReader(messageBytes))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range webhook.Headers {
+		req.Header.Set(key, value)
+	}
+	return send(req)
+}
+
+func (telegram TelegramConfig) Send(message Message) (err error) {
+	messageBytes, err := json.Marshal(map[string]string{"chat_id": telegram.ChatId, "text": message.Text})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/bot%s/sendMessage", telegramUrl, telegram.Token), bytes.NewReader(messageBytes))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = send(req)
+	// the token is in the url, not to be logged
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = strings.ReplaceAll(urlErr.URL, telegram.Token, "REDACTED")
+	}
+	return
+}
+
+func (email EmailConfig) Send(message Message) (err error) {
+	address := net.JoinHostPort(email.Host, fmt.Sprint(email.Port))
+	var auth smtp.Auth
+	if email.Username != "" {
+		auth = smtp.PlainAuth("", email.Username, email.Password, email.Host)
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		email.From, strings.Join(email.To, ", "), subject(message), message.Text)
+	return smtp.SendMail(address, auth, email.From, email.To, []byte(body))
+}
+
+func (ntfy NtfyConfig) Send(message Message) (err error) {
+	server := ntfy.Server
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s", strings.TrimSuffix(server, "/"), ntfy.Topic), strings.NewReader(message.Text))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Title", subject(message))
+	req.Header.Set("Tags", string(message.Event))
+	if ntfy.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ntfy.Token))
+	}
+	return send(req)
+}
+
+func subject(message Message) string {
+	if message.Charger == "" {
+		return fmt.Sprintf("Wallbox Nord Pool: %s", message.Event)
+	}
+	return fmt.Sprintf("Wallbox Nord Pool: %s %s", message.Charger, message.Event)
+}
+
+func send(req *http.Request) (err error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	responseBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("invalid response %d %s", resp.StatusCode, responseBytes)
+	}
+	return
+}
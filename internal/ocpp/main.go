@@ -0,0 +1,337 @@
+package ocpp
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+const (
+	subprotocol        = "ocpp1.6"
+	callType           = 2
+	callResultType     = 3
+	callErrorType      = 4
+	heartbeatInterval  = 300
+	defaultCallTimeout = 30 * time.Second
+	defaultIdTag       = "wallbox_nord_pool"
+	defaultConnectorId = 1
+	defaultMaxCurrent  = 32
+)
+
+var (
+	errNotConnected = errors.New("charge point not connected")
+	errCallTimeout  = errors.New("call timed out")
+	errCallFailed   = errors.New("call failed")
+	errRejected     = errors.New("rejected by charge point")
+	errNoPower      = errors.New("charge point reports no power")
+)
+
+// Config is the per run OCPP config, the central system itself is served by the daemon.
+type Config struct {
+	// IdTag authorizes the transactions started remotely.
+	IdTag       string        `yaml:"id-tag"`
+	CallTimeout time.Duration `yaml:"call-timeout" validate:"min=0"`
+	// Password authorizes the charge points with HTTP basic auth, OCPP security profile 1, the username being
+	// the charge point id. Charge points without a password can't connect.
+	Password string `yaml:"password" secret:"true"`
+	// ChargePoints have their own password instead.
+	ChargePoints []ChargePointConfig `yaml:"charge-points"`
+}
+
+type ChargePointConfig struct {
+	Id       string `yaml:"id" validate:"required"`
+	Password string `yaml:"password" validate:"required" secret:"true"`
+}
+
+// password returns the password of the charge point, empty when it has none.
+func (config Config) password(id string) string {
+	for _, chargePoint := range config.ChargePoints {
+		if chargePoint.Id == id {
+			return chargePoint.Password
+		}
+	}
+	return config.Password
+}
+
+// HasPassword tells whether the charge point of the device id may connect.
+func (config Config) HasPassword(deviceId string) bool {
+	id, _ := chargePointId(deviceId)
+	return config.password(id) != ""
+}
+
+// statuses maps OCPP 1.6 connector statuses onto the Wallbox ones, so that the flow treats both the same way:
+// a connected car waiting for authorization is LockedWaiting and unlocked by starting a transaction,
+// a transaction suspended by the charging profile is Paused and resumed by raising the limit.
+var statuses = map[string]wallbox.ChargerStatus{
+	"Available":     wallbox.Ready,
+	"Preparing":     wallbox.LockedWaiting,
+	"Charging":      wallbox.Charging,
+	"SuspendedEV":   wallbox.Charging,
+	"SuspendedEVSE": wallbox.Paused,
+	"Finishing":     wallbox.Ready,
+	"Reserved":      wallbox.Locked,
+	"Unavailable":   wallbox.Locked,
+	"Faulted":       wallbox.Error,
+}
+
+// CentralSystem accepts OCPP 1.6J charge points connecting over WebSocket to its path followed by their id.
+type CentralSystem struct {
+	path         string
+	config       Config
+	upgrader     websocket.Upgrader
+	mu           sync.Mutex
+	chargePoints map[string]*ChargePoint
+	transactions atomic.Int64
+}
+
+func NewCentralSystem(path string) *CentralSystem {
+	centralSystem := &CentralSystem{
+		path:         path,
+		upgrader:     websocket.Upgrader{Subprotocols: []string{subprotocol}},
+		chargePoints: map[string]*ChargePoint{},
+	}
+	centralSystem.transactions.Store(time.Now().Unix() % 1000000000)
+	return centralSystem
+}
+
+// Update sets the config of the run, the passwords of charge points connecting from then on.
+func (centralSystem *CentralSystem) Update(config Config) {
+	centralSystem.mu.Lock()
+	defer centralSystem.mu.Unlock()
+	centralSystem.config = config
+}
+
+// authorized checks the basic auth of the charge point.
+func (centralSystem *CentralSystem) authorized(r *http.Request, id string) bool {
+	centralSystem.mu.Lock()
+	password := centralSystem.config.password(id)
+	centralSystem.mu.Unlock()
+	username, got, ok := r.BasicAuth()
+	if !ok || password == "" || username != id {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(password)) == 1
+}
+
+func (centralSystem *CentralSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, centralSystem.path), "/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !centralSystem.authorized(r, id) {
+		slog.Warn("OCPP charge point not authorized", "chargePoint", id, "remote", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="ocpp"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	conn, err := centralSystem.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("OCPP upgrade failed", "chargePoint", id, "error", err)
+		return
+	}
+	chargePoint := &ChargePoint{
+		Id:            id,
+		conn:          conn,
+		centralSystem: centralSystem,
+		connectors:    map[int]*Connector{},
+		pending:       map[string]chan callResult{},
+	}
+	centralSystem.mu.Lock()
+	if previous, ok := centralSystem.chargePoints[id]; ok {
+		previous.conn.Close()
+	}
+	centralSystem.chargePoints[id] = chargePoint
+	centralSystem.mu.Unlock()
+	slog.Info("Charge point connected", "chargePoint", id, "remote", r.RemoteAddr)
+	chargePoint.serve()
+	centralSystem.mu.Lock()
+	if centralSystem.chargePoints[id] == chargePoint {
+		delete(centralSystem.chargePoints, id)
+	}
+	centralSystem.mu.Unlock()
+	slog.Info("Charge point disconnected", "chargePoint", id)
+}
+
+func (centralSystem *CentralSystem) ChargePoint(id string) (chargePoint *ChargePoint, err error) {
+	centralSystem.mu.Lock()
+	defer centralSystem.mu.Unlock()
+	chargePoint, ok := centralSystem.chargePoints[id]
+	if !ok {
+		return nil, fmt.Errorf("%s : %w", id, errNotConnected)
+	}
+	return
+}
+
+// Charger drives a connector of a charge point through the flow. A charge point id may end with :connector.
+func (centralSystem *CentralSystem) Charger(deviceId string, config Config) wallbox.Charger {
+	id, connectorId := chargePointId(deviceId)
+	return Charger{centralSystem: centralSystem, id: id, connectorId: connectorId, config: config}
+}
+
+// chargePointId splits the device id into the charge point id and the connector.
+func chargePointId(deviceId string) (id string, connectorId int) {
+	id, connectorId = deviceId, defaultConnectorId
+	if name, connector, ok := strings.Cut(deviceId, ":"); ok {
+		if parsed, err := strconv.Atoi(connector); err == nil {
+			id, connectorId = name, parsed
+		}
+	}
+	return
+}
+
+// Connector is what the charge point reported about one of its connectors.
+type Connector struct {
+	Status        string
+	ErrorCode     string
+	TransactionId int
+	// Limit is the current of the last charging profile, amperes.
+	Limit    int
+	EnergyWh float64
+	PowerW   float64
+	// PowerAt is when PowerW was reported, zero when the charge point doesn't sample the power.
+	PowerAt time.Time
+}
+
+type ChargePoint struct {
+	Id            string
+	Vendor        string
+	Model         string
+	conn          *websocket.Conn
+	centralSystem *CentralSystem
+	writeMu       sync.Mutex
+	mu            sync.Mutex
+	connectors    map[int]*Connector
+	pending       map[string]chan callResult
+	calls         atomic.Int64
+}
+
+type callResult struct {
+	payload json.RawMessage
+	err     error
+}
+
+// Connector returns a copy of the connector state.
+func (chargePoint *ChargePoint) Connector(connectorId int) Connector {
+	chargePoint.mu.Lock()
+	defer chargePoint.mu.Unlock()
+	return *chargePoint.connector(connectorId)
+}
+
+func (chargePoint *ChargePoint) connector(connectorId int) *Connector {
+	connector, ok := chargePoint.connectors[connectorId]
+	if !ok {
+		connector = &Connector{}
+		chargePoint.connectors[connectorId] = connector
+	}
+	return connector
+}
+
+func (chargePoint *ChargePoint) serve() {
+	defer chargePoint.conn.Close()
+	for {
+		_, message, err := chargePoint.conn.ReadMessage()
+		if err != nil {
+			chargePoint.failPending(err)
+			return
+		}
+		var frame []json.RawMessage
+		var messageType int
+		var uniqueId string
+		if json.Unmarshal(message, &frame) != nil || len(frame) < 3 ||
+			json.Unmarshal(frame[0], &messageType) != nil || json.Unmarshal(frame[1], &uniqueId) != nil {
+			slog.Warn("Invalid OCPP message", "chargePoint", chargePoint.Id, "message", string(message))
+			continue
+		}
+		switch messageType {
+		case callType:
+			var action string
+			_ = json.Unmarshal(frame[2], &action)
+			var payload json.RawMessage
+			if len(frame) > 3 {
+				payload = frame[3]
+			}
+			chargePoint.answer(uniqueId, action, payload)
+		case callResultType:
+			chargePoint.resolve(uniqueId, callResult{payload: frame[2]})
+		case callErrorType:
+			chargePoint.resolve(uniqueId, callResult{err: fmt.Errorf("%s : %w", frame[2:], errCallFailed)})
+		}
+	}
+}
+
+func (chargePoint *ChargePoint) answer(uniqueId string, action string, payload json.RawMessage) {
+	response, err := chargePoint.handle(action, payload)
+	var frame []any
+	if err != nil {
+		slog.Warn("OCPP call not handled", "chargePoint", chargePoint.Id, "action", action, "error", err)
+		frame = []any{callErrorType, uniqueId, "NotImplemented", err.Error(), struct{}{}}
+	} else {
+		frame = []any{callResultType, uniqueId, response}
+	}
+	err = chargePoint.write(frame)
+	if err != nil {
+		slog.Warn("OCPP response failed", "chargePoint", chargePoint.Id, "action", action, "error", err)
+	}
+}
+
+func (chargePoint *ChargePoint) write(frame []any) error {
+	chargePoint.writeMu.Lock()
+	defer chargePoint.writeMu.Unlock()
+	return chargePoint.conn.WriteJSON(frame)
+}
+
+func (chargePoint *ChargePoint) resolve(uniqueId string, result callResult) {
+	chargePoint.mu.Lock()
+	pending, ok := chargePoint.pending[uniqueId]
+	delete(chargePoint.pending, uniqueId)
+	chargePoint.mu.Unlock()
+	if ok {
+		pending <- result
+	}
+}
+
+func (chargePoint *ChargePoint) failPending(err error) {
+	chargePoint.mu.Lock()
+	defer chargePoint.mu.Unlock()
+	for uniqueId, pending := range chargePoint.pending {
+		pending <- callResult{err: err}
+		delete(chargePoint.pending, uniqueId)
+	}
+}
+
+// Call sends an action to the charge point and decodes its result into response.
+func (chargePoint *ChargePoint) Call(action string, request any, response any, timeout time.Duration) (err error) {
+	uniqueId := strconv.FormatInt(chargePoint.calls.Add(1), 10)
+	pending := make(chan callResult, 1)
+	chargePoint.mu.Lock()
+	chargePoint.pending[uniqueId] = pending
+	chargePoint.mu.Unlock()
+	err = chargePoint.write([]any{callType, uniqueId, action, request})
+	if err != nil {
+		chargePoint.resolve(uniqueId, callResult{})
+		return
+	}
+	select {
+	case result := <-pending:
+		if result.err != nil {
+			return fmt.Errorf("%s: %w", action, result.err)
+		}
+		return json.Unmarshal(result.payload, response)
+	case <-time.After(timeout):
+		chargePoint.mu.Lock()
+		delete(chargePoint.pending, uniqueId)
+		chargePoint.mu.Unlock()
+		return fmt.Errorf("%s : %w", action, errCallTimeout)
+	}
+}
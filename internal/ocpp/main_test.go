@@ -0,0 +1,219 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+const testPassword = "secret"
+
+// testCentralSystem accepts the charge points with testPassword.
+func testCentralSystem() *CentralSystem {
+	centralSystem := NewCentralSystem("/ocpp/")
+	centralSystem.Update(Config{Password: testPassword})
+	return centralSystem
+}
+
+// dialChargePoint connects to the server as the charge point id with the password.
+func dialChargePoint(serverUrl string, id string, password string) (conn *websocket.Conn, resp *http.Response, err error) {
+	request, _ := http.NewRequest("GET", serverUrl, nil)
+	request.SetBasicAuth(id, password)
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	return dialer.Dial("ws"+strings.TrimPrefix(serverUrl, "http")+"/ocpp/"+id, request.Header)
+}
+
+// testChargePoint connects to the central system as the charge point id.
+func testChargePoint(t *testing.T, centralSystem *CentralSystem, id string) (conn *websocket.Conn) {
+	server := httptest.NewServer(centralSystem)
+	t.Cleanup(server.Close)
+	conn, _, err := dialChargePoint(server.URL, id, testPassword)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// send sends a call of the charge point and returns the result payload.
+func send(t *testing.T, conn *websocket.Conn, action string, payload any) json.RawMessage {
+	err := conn.WriteJSON([]any{callType, action, action, payload})
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	var frame []json.RawMessage
+	err = conn.ReadJSON(&frame)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	var messageType int
+	_ = json.Unmarshal(frame[0], &messageType)
+	if messageType != callResultType {
+		t.Fatalf("Got %s for %s", frame, action)
+	}
+	return frame[2]
+}
+
+// answer waits for a call of the central system and accepts it.
+func answer(t *testing.T, conn *websocket.Conn) (action string, payload json.RawMessage) {
+	var frame []json.RawMessage
+	err := conn.ReadJSON(&frame)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	_ = json.Unmarshal(frame[2], &action)
+	err = conn.WriteJSON([]any{callResultType, frame[1], StatusResponse{Status: "Accepted"}})
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	return action, frame[3]
+}
+
+func TestAuthorization(t *testing.T) {
+	centralSystem := NewCentralSystem("/ocpp/")
+	centralSystem.Update(Config{Password: testPassword, ChargePoints: []ChargePointConfig{{Id: "cp2", Password: "own"}}})
+	server := httptest.NewServer(centralSystem)
+	defer server.Close()
+	tests := []struct {
+		name       string
+		id         string
+		password   string
+		wantStatus int
+	}{
+		{name: "Shared password", id: "cp1", password: testPassword, wantStatus: http.StatusSwitchingProtocols},
+		{name: "Wrong password", id: "cp1", password: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "Own password", id: "cp2", password: "own", wantStatus: http.StatusSwitchingProtocols},
+		{name: "Shared password of a charge point with its own", id: "cp2", password: testPassword, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := dialChargePoint(server.URL, tt.id, tt.password)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Got status %d, wanted %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	_, resp, _ := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ocpp/cp1", nil)
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Got a connection without basic auth")
+	}
+	if !(Config{Password: testPassword}).HasPassword("cp1:2") || (Config{}).HasPassword("cp1") {
+		t.Errorf("Got wrong passwords")
+	}
+}
+
+func TestChargerStatus(t *testing.T) {
+	centralSystem := testCentralSystem()
+	conn := testChargePoint(t, centralSystem, "cp1")
+	var boot BootNotificationResponse
+	_ = json.Unmarshal(send(t, conn, "BootNotification", BootNotification{ChargePointVendor: "Acme", ChargePointModel: "One"}), &boot)
+	if boot.Status != "Accepted" || boot.Interval != heartbeatInterval {
+		t.Errorf("Got boot response %+v", boot)
+	}
+	charger := centralSystem.Charger("cp1", Config{})
+	tests := []struct {
+		status string
+		want   wallbox.ChargerStatus
+	}{
+		{status: "Preparing", want: wallbox.LockedWaiting},
+		{status: "SuspendedEVSE", want: wallbox.Paused},
+		{status: "SuspendedEV", want: wallbox.Charging},
+		{status: "Faulted", want: wallbox.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			send(t, conn, "StatusNotification", StatusNotification{ConnectorId: 1, ErrorCode: "NoError", Status: tt.status})
+			status, err := charger.GetStatus()
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if status != tt.want {
+				t.Errorf("Got status %s, wanted %s", status, tt.want)
+			}
+		})
+	}
+	_, err := centralSystem.Charger("cp2", Config{}).GetStatus()
+	if err == nil {
+		t.Errorf("Got status of a charge point that is not connected")
+	}
+}
+
+func TestChargingPower(t *testing.T) {
+	centralSystem := testCentralSystem()
+	conn := testChargePoint(t, centralSystem, "cp1")
+	charger := centralSystem.Charger("cp1", Config{})
+	send(t, conn, "StatusNotification", StatusNotification{ConnectorId: 1, Status: "Charging"})
+	_, err := charger.(Charger).GetChargingPower()
+	if !errors.Is(err, errNoPower) {
+		t.Errorf("Got Error %v, wanted %v", err, errNoPower)
+	}
+	var meterValues MeterValues
+	_ = json.Unmarshal([]byte(`{"connectorId":1,"meterValue":[{"sampledValue":[{"value":"1.38","measurand":"Power.Active.Import","unit":"kW"}]}]}`), &meterValues)
+	send(t, conn, "MeterValues", meterValues)
+	power, err := charger.(Charger).GetChargingPower()
+	if err != nil || power != 1380 {
+		t.Errorf("Got power %f, error %v, wanted 1380", power, err)
+	}
+}
+
+func TestChargerActions(t *testing.T) {
+	centralSystem := testCentralSystem()
+	conn := testChargePoint(t, centralSystem, "cp1")
+	send(t, conn, "StatusNotification", StatusNotification{ConnectorId: 2, Status: "Preparing"})
+	charger := centralSystem.Charger("cp1:2", Config{IdTag: "tag", CallTimeout: time.Second})
+	done := make(chan error, 1)
+	go func() { done <- charger.Unlock() }()
+	action, payload := answer(t, conn)
+	var start RemoteStartTransaction
+	_ = json.Unmarshal(payload, &start)
+	if action != "RemoteStartTransaction" || start.ConnectorId != 2 || start.IdTag != "tag" {
+		t.Errorf("Got %s %s", action, payload)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	var started StartTransactionResponse
+	_ = json.Unmarshal(send(t, conn, "StartTransaction", StartTransaction{ConnectorId: 2, IdTag: "tag"}), &started)
+	if started.TransactionId == 0 || started.IdTagInfo.Status != "Accepted" {
+		t.Errorf("Got start response %+v", started)
+	}
+	go func() { done <- charger.SetMaxCurrent(16) }()
+	action, payload = answer(t, conn)
+	var profile SetChargingProfile
+	_ = json.Unmarshal(payload, &profile)
+	if action != "SetChargingProfile" || profile.CsChargingProfiles.ChargingSchedule.ChargingSchedulePeriod[0].Limit != 16 {
+		t.Errorf("Got %s %s", action, payload)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	go func() { done <- charger.PauseCharging() }()
+	answer(t, conn)
+	<-done
+	go func() { done <- charger.ResumeCharging() }()
+	action, payload = answer(t, conn)
+	_ = json.Unmarshal(payload, &profile)
+	if action != "SetChargingProfile" || profile.CsChargingProfiles.ChargingSchedule.ChargingSchedulePeriod[0].Limit != 16 {
+		t.Errorf("Got %s %s, wanted the limit restored", action, payload)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	current, _ := charger.GetMaxCurrent()
+	if current != 16 {
+		t.Errorf("Got current %d, wanted 16", current)
+	}
+}
@@ -0,0 +1,333 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+	"wallbox_nord_pool/internal/metrics"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+type IdTagInfo struct {
+	Status string `json:"status"`
+}
+
+type BootNotification struct {
+	ChargePointVendor string `json:"chargePointVendor"`
+	ChargePointModel  string `json:"chargePointModel"`
+}
+
+type BootNotificationResponse struct {
+	Status      string `json:"status"`
+	CurrentTime string `json:"currentTime"`
+	Interval    int    `json:"interval"`
+}
+
+type HeartbeatResponse struct {
+	CurrentTime string `json:"currentTime"`
+}
+
+type StatusNotification struct {
+	ConnectorId int    `json:"connectorId"`
+	ErrorCode   string `json:"errorCode"`
+	Status      string `json:"status"`
+}
+
+type MeterValues struct {
+	ConnectorId int `json:"connectorId"`
+	MeterValue  []struct {
+		SampledValue []SampledValue `json:"sampledValue"`
+	} `json:"meterValue"`
+}
+
+type SampledValue struct {
+	Value     string `json:"value"`
+	Measurand string `json:"measurand"`
+	Unit      string `json:"unit"`
+}
+
+type StartTransaction struct {
+	ConnectorId int    `json:"connectorId"`
+	IdTag       string `json:"idTag"`
+	MeterStart  int    `json:"meterStart"`
+}
+
+type StartTransactionResponse struct {
+	TransactionId int       `json:"transactionId"`
+	IdTagInfo     IdTagInfo `json:"idTagInfo"`
+}
+
+type StopTransaction struct {
+	TransactionId int `json:"transactionId"`
+	MeterStop     int `json:"meterStop"`
+}
+
+type IdTagInfoResponse struct {
+	IdTagInfo IdTagInfo `json:"idTagInfo"`
+}
+
+type RemoteStartTransaction struct {
+	ConnectorId int    `json:"connectorId"`
+	IdTag       string `json:"idTag"`
+}
+
+type RemoteStopTransaction struct {
+	TransactionId int `json:"transactionId"`
+}
+
+type SetChargingProfile struct {
+	ConnectorId        int             `json:"connectorId"`
+	CsChargingProfiles ChargingProfile `json:"csChargingProfiles"`
+}
+
+type ChargingProfile struct {
+	ChargingProfileId      int              `json:"chargingProfileId"`
+	StackLevel             int              `json:"stackLevel"`
+	ChargingProfilePurpose string           `json:"chargingProfilePurpose"`
+	ChargingProfileKind    string           `json:"chargingProfileKind"`
+	ChargingSchedule       ChargingSchedule `json:"chargingSchedule"`
+}
+
+type ChargingSchedule struct {
+	ChargingRateUnit       string                   `json:"chargingRateUnit"`
+	ChargingSchedulePeriod []ChargingSchedulePeriod `json:"chargingSchedulePeriod"`
+}
+
+type ChargingSchedulePeriod struct {
+	StartPeriod int     `json:"startPeriod"`
+	Limit       float64 `json:"limit"`
+}
+
+// StatusResponse is the response of the remote actions and SetChargingProfile.
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+var accepted = IdTagInfo{Status: "Accepted"}
+
+// handle answers the calls of the charge point.
+func (chargePoint *ChargePoint) handle(action string, payload json.RawMessage) (response any, err error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	switch action {
+	case "BootNotification":
+		var request BootNotification
+		err = json.Unmarshal(payload, &request)
+		chargePoint.mu.Lock()
+		chargePoint.Vendor, chargePoint.Model = request.ChargePointVendor, request.ChargePointModel
+		chargePoint.mu.Unlock()
+		slog.Info("Charge point booted", "chargePoint", chargePoint.Id, "vendor", request.ChargePointVendor, "model", request.ChargePointModel)
+		return BootNotificationResponse{Status: "Accepted", CurrentTime: now, Interval: heartbeatInterval}, err
+	case "Heartbeat":
+		return HeartbeatResponse{CurrentTime: now}, nil
+	case "Authorize":
+		return IdTagInfoResponse{accepted}, nil
+	case "StatusNotification":
+		var request StatusNotification
+		err = json.Unmarshal(payload, &request)
+		chargePoint.mu.Lock()
+		connector := chargePoint.connector(request.ConnectorId)
+		connector.Status, connector.ErrorCode = request.Status, request.ErrorCode
+		chargePoint.mu.Unlock()
+		slog.Info("Charge point status", "chargePoint", chargePoint.Id, "connector", request.ConnectorId, "status", request.Status)
+		return struct{}{}, err
+	case "MeterValues":
+		var request MeterValues
+		err = json.Unmarshal(payload, &request)
+		chargePoint.meterValues(request)
+		return struct{}{}, err
+	case "StartTransaction":
+		var request StartTransaction
+		err = json.Unmarshal(payload, &request)
+		transactionId := int(chargePoint.centralSystem.transactions.Add(1))
+		chargePoint.mu.Lock()
+		chargePoint.connector(request.ConnectorId).TransactionId = transactionId
+		chargePoint.mu.Unlock()
+		slog.Info("Transaction started", "chargePoint", chargePoint.Id, "connector", request.ConnectorId, "transaction", transactionId)
+		return StartTransactionResponse{TransactionId: transactionId, IdTagInfo: accepted}, err
+	case "StopTransaction":
+		var request StopTransaction
+		err = json.Unmarshal(payload, &request)
+		chargePoint.mu.Lock()
+		for _, connector := range chargePoint.connectors {
+			if connector.TransactionId == request.TransactionId {
+				connector.TransactionId = 0
+			}
+		}
+		chargePoint.mu.Unlock()
+		slog.Info("Transaction stopped", "chargePoint", chargePoint.Id, "transaction", request.TransactionId, "meterStop", request.MeterStop)
+		return IdTagInfoResponse{accepted}, err
+	default:
+		return nil, fmt.Errorf("%s is not supported", action)
+	}
+}
+
+func (chargePoint *ChargePoint) meterValues(request MeterValues) {
+	chargePoint.mu.Lock()
+	defer chargePoint.mu.Unlock()
+	connector := chargePoint.connector(request.ConnectorId)
+	for _, meterValue := range request.MeterValue {
+		for _, sampled := range meterValue.SampledValue {
+			value, err := strconv.ParseFloat(sampled.Value, 64)
+			if err != nil {
+				continue
+			}
+			if sampled.Unit == "kWh" || sampled.Unit == "kW" {
+				value *= 1000
+			}
+			switch sampled.Measurand {
+			case "", "Energy.Active.Import.Register":
+				connector.EnergyWh = value
+				metrics.SetGauge("ocpp_energy_wh", "Energy meter of the OCPP connector, Wh.", value, "charge_point", chargePoint.Id, "connector", strconv.Itoa(request.ConnectorId))
+			case "Power.Active.Import":
+				connector.PowerW, connector.PowerAt = value, time.Now()
+				metrics.SetGauge("ocpp_power_watts", "Power of the OCPP connector, W.", value, "charge_point", chargePoint.Id, "connector", strconv.Itoa(request.ConnectorId))
+			}
+		}
+	}
+}
+
+// Charger is a connector of a charge point driven through the wallbox.Charger interface.
+type Charger struct {
+	centralSystem *CentralSystem
+	id            string
+	connectorId   int
+	config        Config
+}
+
+func (charger Charger) chargePoint() (*ChargePoint, error) {
+	return charger.centralSystem.ChargePoint(charger.id)
+}
+
+func (charger Charger) call(action string, request any) (err error) {
+	chargePoint, err := charger.chargePoint()
+	if err != nil {
+		return
+	}
+	timeout := charger.config.CallTimeout
+	if timeout <= 0 {
+		timeout = defaultCallTimeout
+	}
+	var response StatusResponse
+	err = chargePoint.Call(action, request, &response, timeout)
+	if err != nil {
+		return
+	}
+	if response.Status != "Accepted" {
+		return fmt.Errorf("%s %s : %w", action, response.Status, errRejected)
+	}
+	return
+}
+
+func (charger Charger) connector() (connector Connector, err error) {
+	chargePoint, err := charger.chargePoint()
+	if err != nil {
+		return
+	}
+	return chargePoint.Connector(charger.connectorId), nil
+}
+
+func (charger Charger) GetStatus() (status wallbox.ChargerStatus, err error) {
+	connector, err := charger.connector()
+	if err != nil {
+		return
+	}
+	status, ok := statuses[connector.Status]
+	if !ok {
+		return wallbox.Unknown, nil
+	}
+	return
+}
+
+// GetMaxCurrent returns the limit of the last charging profile, 0 when none was set.
+func (charger Charger) GetMaxCurrent() (current int, err error) {
+	connector, err := charger.connector()
+	return connector.Limit, err
+}
+
+func (charger Charger) SetMaxCurrent(current int) (err error) {
+	err = charger.setLimit(current)
+	if err != nil {
+		return
+	}
+	chargePoint, err := charger.chargePoint()
+	if err != nil {
+		return
+	}
+	chargePoint.mu.Lock()
+	chargePoint.connector(charger.connectorId).Limit = current
+	chargePoint.mu.Unlock()
+	return
+}
+
+// GetChargingPower returns the last Power.Active.Import of the connector.
+func (charger Charger) GetChargingPower() (power float64, err error) {
+	connector, err := charger.connector()
+	if err != nil {
+		return
+	}
+	if connector.PowerAt.IsZero() {
+		return 0, fmt.Errorf("%s : %w", charger.id, errNoPower)
+	}
+	return connector.PowerW, nil
+}
+
+// SetEnergyCost does nothing, OCPP 1.6 has no energy cost.
+func (charger Charger) SetEnergyCost(_ float64) error {
+	return nil
+}
+
+// Unlock authorizes the waiting car by starting a transaction.
+func (charger Charger) Unlock() error {
+	idTag := charger.config.IdTag
+	if idTag == "" {
+		idTag = defaultIdTag
+	}
+	return charger.call("RemoteStartTransaction", RemoteStartTransaction{ConnectorId: charger.connectorId, IdTag: idTag})
+}
+
+// PauseCharging suspends the transaction with a zero current charging profile.
+func (charger Charger) PauseCharging() error {
+	return charger.setLimit(0)
+}
+
+// ResumeCharging restores the current of the suspended transaction, or starts one.
+func (charger Charger) ResumeCharging() (err error) {
+	connector, err := charger.connector()
+	if err != nil {
+		return
+	}
+	if connector.TransactionId == 0 {
+		return charger.Unlock()
+	}
+	limit := connector.Limit
+	if limit <= 0 {
+		limit = defaultMaxCurrent
+	}
+	return charger.setLimit(limit)
+}
+
+// StopTransaction ends the transaction of the connector.
+func (charger Charger) StopTransaction() (err error) {
+	connector, err := charger.connector()
+	if err != nil {
+		return
+	}
+	return charger.call("RemoteStopTransaction", RemoteStopTransaction{TransactionId: connector.TransactionId})
+}
+
+func (charger Charger) setLimit(current int) error {
+	return charger.call("SetChargingProfile", SetChargingProfile{
+		ConnectorId: charger.connectorId,
+		CsChargingProfiles: ChargingProfile{
+			ChargingProfileId:      1,
+			ChargingProfilePurpose: "TxDefaultProfile",
+			ChargingProfileKind:    "Relative",
+			ChargingSchedule: ChargingSchedule{
+				ChargingRateUnit:       "A",
+				ChargingSchedulePeriod: []ChargingSchedulePeriod{{StartPeriod: 0, Limit: float64(current)}},
+			},
+		},
+	})
+}
@@ -0,0 +1,183 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Config fields tagged with secret:"true", strings or the values of string maps, may hold a reference instead of the value:
+//
+//	env:VAR                  environment variable
+//	file:/path               file content without the trailing newline
+//	ssm:/path                SSM parameter, decrypted
+//	secretsmanager:name      Secrets Manager secret string
+//	secretsmanager:name#key  key of a JSON Secrets Manager secret
+//
+// Values without a known scheme are used as they are.
+const tagName = "secret"
+
+var (
+	errSecretNotFound = errors.New("secret not found")
+)
+
+type Resolver interface {
+	Resolve(reference string) (value string, err error)
+}
+
+// Resolvers maps reference schemes to their resolver.
+type Resolvers map[string]Resolver
+
+// Offline returns the resolvers that need no AWS access.
+func Offline() Resolvers {
+	return Resolvers{"env": Env{}, "file": File{}}
+}
+
+// Default returns all resolvers, AWS ones using sess.
+func Default(sess *session.Session) Resolvers {
+	resolvers := Offline()
+	resolvers["ssm"] = SSM{ssm.New(sess)}
+	resolvers["secretsmanager"] = SecretsManager{secretsmanager.New(sess)}
+	return resolvers
+}
+
+func (resolvers Resolvers) Resolve(value string) (string, error) {
+	scheme, reference, ok := strings.Cut(value, ":")
+	resolver, known := resolvers[scheme]
+	if !ok || !known {
+		return value, nil
+	}
+	resolved, err := resolver.Resolve(reference)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", scheme, err)
+	}
+	return resolved, nil
+}
+
+// ResolveFields replaces references in the string fields tagged as secret, anywhere in config.
+func (resolvers Resolvers) ResolveFields(config any) error {
+	return resolvers.resolveValue(reflect.ValueOf(config), false)
+}
+
+func (resolvers Resolvers) resolveValue(v reflect.Value, secret bool) (err error) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		return resolvers.resolveValue(v.Elem(), secret)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			err = resolvers.resolveValue(v.Field(i), v.Type().Field(i).Tag.Get(tagName) == "true")
+			if err != nil {
+				return fmt.Errorf("%s: %w", v.Type().Field(i).Name, err)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			err = resolvers.resolveValue(v.Index(i), secret)
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		if !secret || v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			var resolved string
+			resolved, err = resolvers.Resolve(iter.Value().String())
+			if err != nil {
+				return fmt.Errorf("%v: %w", iter.Key(), err)
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(resolved).Convert(v.Type().Elem()))
+		}
+	case reflect.String:
+		if !secret || !v.CanSet() {
+			return
+		}
+		var resolved string
+		resolved, err = resolvers.Resolve(v.String())
+		if err != nil {
+			return
+		}
+		v.SetString(resolved)
+	}
+	return
+}
+
+type Env struct{}
+
+func (Env) Resolve(reference string) (value string, err error) {
+	value, ok := os.LookupEnv(reference)
+	if !ok {
+		return "", fmt.Errorf("%s : %w", reference, errSecretNotFound)
+	}
+	return
+}
+
+type File struct{}
+
+func (File) Resolve(reference string) (value string, err error) {
+	valueBytes, err := os.ReadFile(reference)
+	if err != nil {
+		return
+	}
+	return strings.TrimRight(string(valueBytes), "\r\n"), nil
+}
+
+type SSM struct {
+	svc *ssm.SSM
+}
+
+func (resolver SSM) Resolve(reference string) (value string, err error) {
+	output, err := resolver.svc.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(reference),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return
+	}
+	return aws.StringValue(output.Parameter.Value), nil
+}
+
+type SecretsManager struct {
+	svc *secretsmanager.SecretsManager
+}
+
+func (resolver SecretsManager) Resolve(reference string) (value string, err error) {
+	name, key, hasKey := strings.Cut(reference, "#")
+	output, err := resolver.svc.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		return
+	}
+	value = aws.StringValue(output.SecretString)
+	if !hasKey {
+		return
+	}
+	return jsonKey(value, key)
+}
+
+func jsonKey(secret string, key string) (value string, err error) {
+	var values map[string]any
+	err = json.Unmarshal([]byte(secret), &values)
+	if err != nil {
+		return
+	}
+	found, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("%s : %w", key, errSecretNotFound)
+	}
+	return fmt.Sprint(found), nil
+}
@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testConfig struct {
+	Username string `secret:"true"`
+	Password string `secret:"true"`
+	Plain    string
+	Nested   *testNested
+	List     []testNested
+	Headers  map[string]string `secret:"true"`
+	Labels   map[string]string
+}
+
+type testNested struct {
+	Token string `secret:"true"`
+}
+
+func TestResolveFields(t *testing.T) {
+	t.Setenv("WNP_TEST_PASSWORD", "hunter2")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenFile, []byte("abc\n"), 0600)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	config := testConfig{
+		Username: "me",
+		Password: "env:WNP_TEST_PASSWORD",
+		Plain:    "env:WNP_TEST_PASSWORD",
+		Nested:   &testNested{Token: "file:" + tokenFile},
+		List:     []testNested{{Token: "env:WNP_TEST_PASSWORD"}},
+		Headers:  map[string]string{"Authorization": "env:WNP_TEST_PASSWORD", "Accept": "text/plain"},
+		Labels:   map[string]string{"owner": "env:WNP_TEST_PASSWORD"},
+	}
+	err = Offline().ResolveFields(&config)
+	if err != nil {
+		t.Fatalf("Got Error %s", err)
+	}
+	want := testConfig{
+		Username: "me",
+		Password: "hunter2",
+		Plain:    "env:WNP_TEST_PASSWORD",
+		Nested:   &testNested{Token: "abc"},
+		List:     []testNested{{Token: "hunter2"}},
+	}
+	if config.Username != want.Username || config.Password != want.Password || config.Plain != want.Plain ||
+		config.Nested.Token != want.Nested.Token || config.List[0].Token != want.List[0].Token {
+		t.Errorf("Got %+v, wanted %+v", config, want)
+	}
+	if config.Headers["Authorization"] != "hunter2" || config.Headers["Accept"] != "text/plain" || config.Labels["owner"] != "env:WNP_TEST_PASSWORD" {
+		t.Errorf("Got headers %v labels %v", config.Headers, config.Labels)
+	}
+	err = Offline().ResolveFields(&testConfig{Headers: map[string]string{"Authorization": "env:WNP_TEST_MISSING"}})
+	if err == nil {
+		t.Errorf("Got no error for a missing header secret")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Literal", value: "password", want: "password"},
+		{name: "Unknown scheme", value: "https://example.com", want: "https://example.com"},
+		{name: "Missing env", value: "env:WNP_TEST_MISSING", wantErr: true},
+		{name: "Missing file", value: "file:/does/not/exist", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Offline().Resolve(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, wanted error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Got %s, wanted %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJsonKey(t *testing.T) {
+	value, err := jsonKey(`{"username":"me","password":"hunter2"}`, "password")
+	if err != nil || value != "hunter2" {
+		t.Errorf("Got %s, %v", value, err)
+	}
+	_, err = jsonKey(`{"username":"me"}`, "password")
+	if !errors.Is(err, errSecretNotFound) {
+		t.Errorf("Got error %v, wanted %v", err, errSecretNotFound)
+	}
+}
@@ -0,0 +1,92 @@
+package site
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	defaultMinCurrent = 6
+	defaultMaxCurrent = 32
+)
+
+type Config struct {
+	MaxCurrent int `yaml:"max-current" validate:"min=0"`
+}
+
+// Demand is a charger that wants to draw current from the site.
+type Demand struct {
+	Name       string
+	Priority   int
+	Deadline   time.Time
+	MinCurrent int
+	MaxCurrent int
+}
+
+type Allocation struct {
+	Name    string
+	Current int
+	Paused  bool
+}
+
+func (config Config) Enabled() bool {
+	return config.MaxCurrent > 0
+}
+
+// Allocate shares the capacity between demands. Higher priority goes first, then the earlier deadline.
+// Every charger gets its minimum current before any gets more, chargers that don't fit are paused.
+// Allocations are returned in the order of demands.
+func Allocate(capacity int, demands []Demand) (allocations []Allocation) {
+	allocations = make([]Allocation, len(demands))
+	order := make([]int, len(demands))
+	for i, demand := range demands {
+		order[i] = i
+		allocations[i].Name = demand.Name
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return moreUrgent(demands[order[a]], demands[order[b]])
+	})
+	for _, i := range order {
+		minCurrent, _ := currentLimits(demands[i])
+		if capacity < minCurrent {
+			allocations[i].Paused = true
+			continue
+		}
+		allocations[i].Current = minCurrent
+		capacity -= minCurrent
+	}
+	for _, i := range order {
+		if allocations[i].Paused {
+			continue
+		}
+		minCurrent, maxCurrent := currentLimits(demands[i])
+		extra := min(maxCurrent-minCurrent, capacity)
+		allocations[i].Current += extra
+		capacity -= extra
+	}
+	return
+}
+
+func moreUrgent(a Demand, b Demand) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.Deadline.IsZero() || b.Deadline.IsZero() {
+		return !a.Deadline.IsZero()
+	}
+	return a.Deadline.Before(b.Deadline)
+}
+
+func currentLimits(demand Demand) (minCurrent int, maxCurrent int) {
+	minCurrent, maxCurrent = demand.MinCurrent, demand.MaxCurrent
+	if minCurrent <= 0 {
+		minCurrent = defaultMinCurrent
+	}
+	if maxCurrent <= 0 {
+		maxCurrent = defaultMaxCurrent
+	}
+	if maxCurrent < minCurrent {
+		maxCurrent = minCurrent
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package site
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAllocate(t *testing.T) {
+	now := time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name            string
+		capacity        int
+		demands         []Demand
+		wantAllocations []Allocation
+	}{
+		{
+			name:            "Empty",
+			capacity:        32,
+			demands:         []Demand{},
+			wantAllocations: []Allocation{},
+		},
+		{
+			name:     "Enough capacity",
+			capacity: 64,
+			demands: []Demand{
+				{Name: "a", MaxCurrent: 16},
+				{Name: "b", MaxCurrent: 32},
+			},
+			wantAllocations: []Allocation{{Name: "a", Current: 16}, {Name: "b", Current: 32}},
+		},
+		{
+			name:     "Priority gets remaining capacity",
+			capacity: 20,
+			demands: []Demand{
+				{Name: "low", Priority: 1, MaxCurrent: 16},
+				{Name: "high", Priority: 2, MaxCurrent: 16},
+			},
+			wantAllocations: []Allocation{{Name: "low", Current: 6}, {Name: "high", Current: 14}},
+		},
+		{
+			name:     "Lowest priority paused",
+			capacity: 14,
+			demands: []Demand{
+				{Name: "low", Priority: 1},
+				{Name: "high", Priority: 3},
+				{Name: "middle", Priority: 2},
+			},
+			wantAllocations: []Allocation{{Name: "low", Paused: true}, {Name: "high", Current: 8}, {Name: "middle", Current: 6}},
+		},
+		{
+			name:     "Earlier deadline first",
+			capacity: 10,
+			demands: []Demand{
+				{Name: "later", Deadline: now.Add(6 * time.Hour)},
+				{Name: "sooner", Deadline: now.Add(2 * time.Hour)},
+				{Name: "no deadline"},
+			},
+			wantAllocations: []Allocation{{Name: "later", Paused: true}, {Name: "sooner", Current: 10}, {Name: "no deadline", Paused: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations := Allocate(tt.capacity, tt.demands)
+			if !reflect.DeepEqual(allocations, tt.wantAllocations) {
+				t.Errorf("Allocate() = %v, want %v", allocations, tt.wantAllocations)
+			}
+		})
+	}
+}
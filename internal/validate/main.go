@@ -0,0 +1,312 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rules are set in the validate struct tag, separated by commas:
+//
+//	required      the value is not empty
+//	min=N, max=N  numeric range
+//	timezone      a time.LoadLocation name, when not empty
+//	oneof=a b c   one of the values, case-insensitive, when not empty
+//
+// Rules of a slice of scalars apply to every element.
+const tagName = "validate"
+
+// Scalar is implemented by struct types written as a single YAML number or string, for example prices with units.
+// Their min and max rules check Number.
+type Scalar interface {
+	Number() float64
+}
+
+var (
+	nodeType     = reflect.TypeOf(yaml.Node{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	scalarType   = reflect.TypeOf((*Scalar)(nil)).Elem()
+)
+
+type Problem struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (problem Problem) String() string {
+	if problem.Line > 0 {
+		return fmt.Sprintf("%s: %s (line %d)", problem.Path, problem.Message, problem.Line)
+	}
+	return fmt.Sprintf("%s: %s", problem.Path, problem.Message)
+}
+
+type Problems []Problem
+
+func (problems Problems) Error() string {
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = problem.String()
+	}
+	return fmt.Sprintf("invalid config:\n%s", strings.Join(messages, "\n"))
+}
+
+// Decode strictly decodes YAML into out and checks the rules. Unknown keys are problems too.
+// Lines keeps the line of every path so that callers can report their own checks.
+func Decode(in []byte, out any) (lines Lines, problems Problems) {
+	lines = Lines{}
+	var document yaml.Node
+	err := yaml.Unmarshal(in, &document)
+	if err != nil {
+		return lines, Problems{{Path: "", Message: err.Error()}}
+	}
+	if len(document.Content) == 0 {
+		return lines, Problems{{Path: "", Message: "empty document"}}
+	}
+	return lines, DecodeNode(document.Content[0], out, lines)
+}
+
+// DecodeNode is Decode for an already parsed node.
+func DecodeNode(node *yaml.Node, out any, lines Lines) (problems Problems) {
+	checkKeys(node, reflect.TypeOf(out), "", lines, &problems)
+	err := node.Decode(out)
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		for _, message := range typeError.Errors {
+			problems = append(problems, Problem{Message: message})
+		}
+	} else if err != nil {
+		problems = append(problems, Problem{Message: err.Error()})
+	}
+	checkRules(reflect.ValueOf(out), "", "", lines, &problems)
+	return
+}
+
+// Lines maps YAML paths, for example chargers[0].device-id, to their line.
+type Lines map[string]int
+
+// Problem reports at the line of path, or of the closest parent for missing keys.
+func (lines Lines) Problem(path string, message string) Problem {
+	for parent := path; parent != ""; parent = parent[:max(strings.LastIndexAny(parent, ".["), 0)] {
+		if line, ok := lines[parent]; ok {
+			return Problem{Path: path, Line: line, Message: message}
+		}
+	}
+	return Problem{Path: path, Message: message}
+}
+
+func checkKeys(node *yaml.Node, t reflect.Type, path string, lines Lines, problems *Problems) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == nodeType || node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := join(path, key.Value)
+			lines[keyPath] = key.Line
+			field, ok := fields[key.Value]
+			if !ok {
+				*problems = append(*problems, Problem{Path: keyPath, Line: key.Line, Message: "unknown key"})
+				continue
+			}
+			checkKeys(value, field.Type, keyPath, lines, problems)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			lines[itemPath] = item.Line
+			checkKeys(item, t.Elem(), itemPath, lines, problems)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyPath := join(path, node.Content[i].Value)
+			lines[keyPath] = node.Content[i].Line
+			checkKeys(node.Content[i+1], t.Elem(), keyPath, lines, problems)
+		}
+	}
+}
+
+func checkRules(v reflect.Value, path string, rules string, lines Lines, problems *Problems) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			if hasRule(rules, "required") {
+				*problems = append(*problems, lines.Problem(path, "is required"))
+			}
+			return
+		}
+		v = v.Elem()
+	}
+	for _, rule := range splitRules(rules) {
+		message := checkRule(v, rule)
+		if message != "" {
+			*problems = append(*problems, lines.Problem(path, message))
+		}
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == nodeType || v.Type().Implements(scalarType) {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name, ok := yamlName(field)
+			if !ok {
+				continue
+			}
+			checkRules(v.Field(i), join(path, name), field.Tag.Get(tagName), lines, problems)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			itemRules := ""
+			if isScalar(v.Type().Elem()) {
+				itemRules = withoutRule(rules, "required")
+			}
+			checkRules(v.Index(i), fmt.Sprintf("%s[%d]", path, i), itemRules, lines, problems)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			checkRules(v.MapIndex(key), join(path, fmt.Sprint(key.Interface())), "", lines, problems)
+		}
+	}
+}
+
+func checkRule(v reflect.Value, rule string) string {
+	name, argument, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required":
+		if v.IsZero() {
+			return "is required"
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(argument, 64)
+		value, ok := number(v)
+		if err != nil || !ok {
+			return ""
+		}
+		if name == "min" && value < limit {
+			return fmt.Sprintf("must be at least %s", argument)
+		}
+		if name == "max" && value > limit {
+			return fmt.Sprintf("must be at most %s", argument)
+		}
+	case "timezone":
+		if v.Kind() == reflect.String && v.String() != "" {
+			if _, err := time.LoadLocation(v.String()); err != nil {
+				return fmt.Sprintf("unknown timezone %s", v.String())
+			}
+		}
+	case "oneof":
+		if v.Kind() == reflect.String && v.String() != "" {
+			for _, option := range strings.Fields(argument) {
+				if strings.EqualFold(option, v.String()) {
+					return ""
+				}
+			}
+			return fmt.Sprintf("must be one of %s", strings.Join(strings.Fields(argument), ", "))
+		}
+	}
+	return ""
+}
+
+func number(v reflect.Value) (float64, bool) {
+	if v.Type().Implements(scalarType) {
+		return v.Interface().(Scalar).Number(), true
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func isScalar(t reflect.Type) bool {
+	if t.Implements(scalarType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface:
+		return false
+	default:
+		return true
+	}
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := yamlName(t.Field(i)); ok {
+			fields[name] = t.Field(i)
+		}
+	}
+	return fields
+}
+
+func yamlName(field reflect.StructField) (name string, ok bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ = strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, true
+}
+
+func splitRules(rules string) []string {
+	if rules == "" {
+		return nil
+	}
+	return strings.Split(rules, ",")
+}
+
+func hasRule(rules string, name string) bool {
+	for _, rule := range splitRules(rules) {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutRule(rules string, name string) string {
+	var kept []string
+	for _, rule := range splitRules(rules) {
+		if rule != name {
+			kept = append(kept, rule)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
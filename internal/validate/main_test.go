@@ -0,0 +1,118 @@
+package validate
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name     string        `yaml:"name" validate:"required"`
+	Hour     int           `yaml:"hour" validate:"min=0,max=23"`
+	Zone     string        `yaml:"zone" validate:"oneof=lt lv"`
+	Timezone string        `yaml:"timezone" validate:"timezone"`
+	Dedup    time.Duration `yaml:"dedup" validate:"min=0"`
+	Limit    *float64      `yaml:"limit" validate:"min=0"`
+	Items    []testItem    `yaml:"items"`
+	Tags     []string      `yaml:"tags" validate:"oneof=a b"`
+	Fee      testAmount    `yaml:"fee" validate:"min=0"`
+}
+
+// testAmount is a struct written as a scalar, like a price with a unit.
+type testAmount struct {
+	Value float64
+}
+
+func (amount *testAmount) UnmarshalYAML(node *yaml.Node) error {
+	return node.Decode(&amount.Value)
+}
+
+func (amount testAmount) Number() float64 {
+	return amount.Value
+}
+
+type testItem struct {
+	Id string `yaml:"id" validate:"required"`
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name         string
+		yaml         string
+		wantProblems Problems
+	}{
+		{
+			name:         "Valid",
+			yaml:         "name: a\nhour: 23\nzone: LT\ntimezone: Europe/Vilnius\ndedup: 1h\nitems:\n  - id: x\ntags: [a]\n",
+			wantProblems: nil,
+		},
+		{
+			name: "Unknown keys",
+			yaml: "name: a\nhours: 1\nitems:\n  - id: x\n    idd: y\n",
+			wantProblems: Problems{
+				{Path: "hours", Line: 2, Message: "unknown key"},
+				{Path: "items[0].idd", Line: 5, Message: "unknown key"},
+			},
+		},
+		{
+			name: "Rules",
+			yaml: "name: a\nhour: 24\nzone: se3\ntimezone: Mars/Base\nlimit: -1\nitems:\n  - {}\ntags: [a, c]\n",
+			wantProblems: Problems{
+				{Path: "hour", Line: 2, Message: "must be at most 23"},
+				{Path: "zone", Line: 3, Message: "must be one of lt, lv"},
+				{Path: "timezone", Line: 4, Message: "unknown timezone Mars/Base"},
+				{Path: "limit", Line: 5, Message: "must be at least 0"},
+				{Path: "items[0].id", Line: 7, Message: "is required"},
+				{Path: "tags[1]", Line: 8, Message: "must be one of a, b"},
+			},
+		},
+		{
+			name:         "Missing required",
+			yaml:         "hour: 1\n",
+			wantProblems: Problems{{Path: "name", Message: "is required"}},
+		},
+		{
+			name:         "Scalar struct",
+			yaml:         "name: a\nfee: -2\n",
+			wantProblems: Problems{{Path: "fee", Line: 2, Message: "must be at least 0"}},
+		},
+		{
+			name:         "Wrong type",
+			yaml:         "name: a\nhour: noon\n",
+			wantProblems: Problems{{Message: "line 2: cannot unmarshal !!str `noon` into int"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config testConfig
+			_, problems := Decode([]byte(tt.yaml), &config)
+			if !reflect.DeepEqual(problems, tt.wantProblems) {
+				t.Errorf("Got problems %v, wanted %v", problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := Schema(testConfig{}, "test")
+	properties := schema["properties"].(map[string]any)
+	hour := properties["hour"].(map[string]any)
+	if hour["type"] != "integer" || hour["minimum"] != 0.0 || hour["maximum"] != 23.0 {
+		t.Errorf("Got hour schema %v", hour)
+	}
+	if !reflect.DeepEqual(schema["required"], []string{"name"}) {
+		t.Errorf("Got required %v", schema["required"])
+	}
+	tags := properties["tags"].(map[string]any)["items"].(map[string]any)
+	if !reflect.DeepEqual(tags["enum"], []string{"a", "b"}) {
+		t.Errorf("Got tags items schema %v", tags)
+	}
+	fee := properties["fee"].(map[string]any)
+	if !reflect.DeepEqual(fee["type"], []string{"number", "string"}) || fee["minimum"] != 0.0 {
+		t.Errorf("Got fee schema %v", fee)
+	}
+	if schema["additionalProperties"] != false {
+		t.Errorf("Got additionalProperties %v", schema["additionalProperties"])
+	}
+}
@@ -0,0 +1,96 @@
+package validate
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Schema returns the JSON Schema of v built from the yaml and validate struct tags.
+func Schema(v any, title string) map[string]any {
+	schema := schemaFor(reflect.TypeOf(v), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = title
+	return schema
+}
+
+func schemaFor(t reflect.Type, rules string) (schema map[string]any) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	schema = map[string]any{}
+	switch {
+	case t == durationType:
+		schema["type"] = "string"
+		schema["pattern"] = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+		return
+	case t == nodeType:
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Implements(scalarType) {
+			schema["type"] = []string{"number", "string"}
+			break
+		}
+		schema["type"] = "object"
+		schema["additionalProperties"] = false
+		properties := map[string]any{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := yamlName(field)
+			if !ok {
+				continue
+			}
+			fieldRules := field.Tag.Get(tagName)
+			properties[name] = schemaFor(field.Type, fieldRules)
+			if hasRule(fieldRules, "required") {
+				required = append(required, name)
+			}
+		}
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	case reflect.Slice:
+		schema["type"] = "array"
+		itemRules := ""
+		if isScalar(t.Elem()) {
+			itemRules = withoutRule(rules, "required")
+		}
+		schema["items"] = schemaFor(t.Elem(), itemRules)
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = schemaFor(t.Elem(), "")
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+	}
+	for _, rule := range splitRules(rules) {
+		name, argument, _ := strings.Cut(rule, "=")
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(argument, 64)
+			if err != nil {
+				continue
+			}
+			if name == "min" {
+				schema["minimum"] = limit
+			} else {
+				schema["maximum"] = limit
+			}
+		case "oneof":
+			schema["enum"] = strings.Fields(argument)
+		case "timezone":
+			schema["description"] = "IANA timezone name, for example Europe/Vilnius"
+		}
+	}
+	return
+}
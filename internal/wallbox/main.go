@@ -8,14 +8,49 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"io"
+	"log/slog"
 	"net/http"
 	"time"
+	"wallbox_nord_pool/internal/crypt"
+	"wallbox_nord_pool/internal/metrics"
 )
 
 type Config struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username string `yaml:"username" secret:"true"`
+	Password string `yaml:"password" secret:"true"`
 	DeviceId string `yaml:"device-id"`
+	// TokenEncryption encrypts the cached user token at rest.
+	TokenEncryption crypt.Config   `yaml:"token-encryption"`
+	Schedule        ScheduleConfig `yaml:"schedule"`
+}
+
+// LogValue keeps the password out of the logs.
+func (config Config) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("username", config.Username), slog.String("device-id", config.DeviceId))
+}
+
+type Account struct {
+	token       string
+	s3svc       *s3.S3
+	awsS3Bucket string
+}
+
+// Charger is what the flow needs of a charger. Wallbox implements it through the cloud API,
+// other backends map their statuses onto ChargerStatus.
+type Charger interface {
+	GetStatus() (status ChargerStatus, err error)
+	GetMaxCurrent() (current int, err error)
+	SetMaxCurrent(current int) (err error)
+	SetEnergyCost(cost float64) (err error)
+	Unlock() (err error)
+	PauseCharging() (err error)
+	ResumeCharging() (err error)
+}
+
+// Meter is implemented by chargers that measure the power the car draws.
+type Meter interface {
+	// GetChargingPower returns the power drawn now, watts.
+	GetChargingPower() (power float64, err error)
 }
 
 type Wallbox struct {
@@ -31,6 +66,8 @@ var (
 	errTokenFileDoesNotExist = errors.New("token file does not exist")
 )
 
+var httpClient = metrics.NewHttpClient("wallbox")
+
 type ChargerStatus string
 
 const (
@@ -49,6 +86,10 @@ const (
 	Updating                    = "Updating"
 )
 
+// ChargerStatuses lists all known statuses, for example to report the status as an enum.
+var ChargerStatuses = []ChargerStatus{Unknown, Waiting, WaitingForCar, Charging, Ready, Paused, Scheduled,
+	Discharging, Error, Disconnected, Locked, LockedWaiting, Updating}
+
 var intToStatusMap = map[int]ChargerStatus{
 	164: Waiting,
 	180: Waiting,
@@ -84,20 +125,36 @@ type UserToken struct {
 	Jwt string `json:"jwt"`
 	Ttl int64  `json:"ttl"`
 }
+
+// LogValue keeps the JWT out of the logs.
+func (token UserToken) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int64("ttl", token.Ttl))
+}
+
 type ChargerData struct {
 	Data struct {
 		ChargerData struct {
-			Id     int `json:"id"`
-			Status int `json:"status"`
-			Locked int `json:"locked"`
+			Id                 int `json:"id"`
+			Status             int `json:"status"`
+			Locked             int `json:"locked"`
+			MaxChargingCurrent int `json:"maxChargingCurrent"`
 		} `json:"chargerData"`
 	} `json:"data"`
 }
 
+// ChargerStatusData is the status of the charger with its readings, charging power in kW.
+type ChargerStatusData struct {
+	ChargingPower float64 `json:"charging_power"`
+}
+
 type ChargerAction struct {
 	Locked int `json:"locked"`
 }
 
+type ChargerCurrent struct {
+	MaxChargingCurrent int `json:"maxChargingCurrent"`
+}
+
 type RemoteAction struct {
 	Action int `json:"action"`
 }
@@ -106,21 +163,35 @@ type ChargerConfig struct {
 	EnergyCost float64 `json:"energyCost,omitempty"`
 }
 
-func NewWallbox(config Config, s3Svc *s3.S3, awsS3Bucket string) (wallbox Wallbox, err error) {
-	token, err := getToken(s3Svc, awsS3Bucket, config.Username, config.Password)
+func NewWallbox(config Config, s3Svc *s3.S3, awsS3Bucket string, tokenKey crypt.KeyProvider) (wallbox Wallbox, err error) {
+	account, err := NewAccount(config, s3Svc, awsS3Bucket, tokenKey)
 	if err != nil {
 		return
 	}
-	return Wallbox{token, config.DeviceId, s3Svc, awsS3Bucket}, err
+	return account.Wallbox(config.DeviceId), err
 }
 
-func getToken(s3svc *s3.S3, awsS3Bucket string, username string, password string) (token string, err error) {
-	userToken, err := readToken(s3svc, awsS3Bucket)
+// NewAccount fetches the user token once so that it can be shared by all chargers of the account.
+// The cached token is encrypted with tokenKey, unless it is nil.
+func NewAccount(config Config, s3Svc *s3.S3, awsS3Bucket string, tokenKey crypt.KeyProvider) (account Account, err error) {
+	token, err := getToken(s3Svc, awsS3Bucket, tokenKey, config.Username, config.Password)
+	if err != nil {
+		return
+	}
+	return Account{token, s3Svc, awsS3Bucket}, err
+}
+
+func (account Account) Wallbox(deviceId string) Wallbox {
+	return Wallbox{account.token, deviceId, account.s3svc, account.awsS3Bucket}
+}
+
+func getToken(s3svc *s3.S3, awsS3Bucket string, tokenKey crypt.KeyProvider, username string, password string) (token string, err error) {
+	userToken, err := readToken(s3svc, awsS3Bucket, tokenKey)
 	if err != nil {
 		if !errors.Is(err, errTokenFileDoesNotExist) {
 			return
 		}
-		userToken, err = getNewToken(s3svc, awsS3Bucket, username, password)
+		userToken, err = getNewToken(s3svc, awsS3Bucket, tokenKey, username, password)
 		if err != nil {
 			return
 		}
@@ -129,12 +200,30 @@ func getToken(s3svc *s3.S3, awsS3Bucket string, username string, password string
 }
 
 func (wallbox Wallbox) GetStatus() (status ChargerStatus, err error) {
+	chargerData, err := wallbox.getChargerData()
+	if err != nil {
+		return
+	}
+	status = mapToStatus(chargerData.Data.ChargerData.Status)
+	return
+}
+
+// GetMaxCurrent returns the max charging current the charger is set to.
+func (wallbox Wallbox) GetMaxCurrent() (current int, err error) {
+	chargerData, err := wallbox.getChargerData()
+	if err != nil {
+		return
+	}
+	return chargerData.Data.ChargerData.MaxChargingCurrent, nil
+}
+
+func (wallbox Wallbox) getChargerData() (chargerData ChargerData, err error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.wall-box.com/v2/charger/%s", wallbox.deviceId), nil)
 	if err != nil {
 		return
 	}
 	wallbox.addHeaders(req)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return
 	}
@@ -149,17 +238,40 @@ func (wallbox Wallbox) GetStatus() (status ChargerStatus, err error) {
 		return
 	}
 
-	var chargerData = ChargerData{}
 	err = json.Unmarshal(tokenBytes, &chargerData)
+	return
+}
+
+func (wallbox Wallbox) GetChargingPower() (power float64, err error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.wall-box.com/chargers/status/%s", wallbox.deviceId), nil)
 	if err != nil {
 		return
 	}
-	status = mapToStatus(chargerData.Data.ChargerData.Status)
-	return
+	wallbox.addHeaders(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = handleHttpError(resp)
+		return
+	}
+	var status ChargerStatusData
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status.ChargingPower * 1000, err
 }
 
 func (wallbox Wallbox) Unlock() (err error) {
-	marshallBytes, err := json.Marshal(ChargerAction{Locked: 0})
+	return wallbox.updateCharger(ChargerAction{Locked: 0})
+}
+
+func (wallbox Wallbox) SetMaxCurrent(current int) (err error) {
+	return wallbox.updateCharger(ChargerCurrent{MaxChargingCurrent: current})
+}
+
+func (wallbox Wallbox) updateCharger(update any) (err error) {
+	marshallBytes, err := json.Marshal(update)
 	if err != nil {
 		return
 	}
@@ -169,7 +281,7 @@ func (wallbox Wallbox) Unlock() (err error) {
 		return
 	}
 	wallbox.addHeaders(req)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return
 	}
@@ -194,7 +306,7 @@ func (wallbox Wallbox) SetEnergyCost(cost float64) (err error) {
 		return
 	}
 	wallbox.addHeaders(req)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return
 	}
@@ -227,7 +339,7 @@ func (wallbox Wallbox) remoteAction(action RemoteAction) (err error) {
 		return
 	}
 	wallbox.addHeaders(req)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return
 	}
@@ -255,13 +367,13 @@ func mapToStatus(status int) (chargerStatus ChargerStatus) {
 	}
 }
 
-func getNewToken(s3svc *s3.S3, awsS3Bucket string, username string, password string) (token UserToken, err error) {
+func getNewToken(s3svc *s3.S3, awsS3Bucket string, tokenKey crypt.KeyProvider, username string, password string) (token UserToken, err error) {
 	req, err := http.NewRequest("GET", "https://api.wall-box.com/auth/token/user", nil)
 	if err != nil {
 		return
 	}
 	req.SetBasicAuth(username, password)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return
 	}
@@ -276,7 +388,7 @@ func getNewToken(s3svc *s3.S3, awsS3Bucket string, username string, password str
 		return
 	}
 
-	err = writeToken(s3svc, awsS3Bucket, tokenBytes)
+	err = writeToken(s3svc, awsS3Bucket, tokenKey, tokenBytes)
 	if err != nil {
 		return
 	}
@@ -289,7 +401,13 @@ func handleHttpError(resp *http.Response) error {
 	return fmt.Errorf("invalid response %d %s", resp.StatusCode, tokenBytes)
 }
 
-func writeToken(s3svc *s3.S3, awsS3Bucket string, token []byte) (err error) {
+func writeToken(s3svc *s3.S3, awsS3Bucket string, tokenKey crypt.KeyProvider, token []byte) (err error) {
+	if tokenKey != nil {
+		token, err = crypt.Seal(tokenKey, token)
+		if err != nil {
+			return
+		}
+	}
 	_, err = s3svc.PutObject(&s3.PutObjectInput{
 		Body:   bytes.NewReader(token),
 		Bucket: &awsS3Bucket,
@@ -298,14 +416,16 @@ func writeToken(s3svc *s3.S3, awsS3Bucket string, token []byte) (err error) {
 	return err
 }
 
-func readToken(s3svc *s3.S3, awsS3Bucket string) (token UserToken, err error) {
+func readToken(s3svc *s3.S3, awsS3Bucket string, tokenKey crypt.KeyProvider) (token UserToken, err error) {
 	input := &s3.GetObjectInput{Bucket: aws.String(awsS3Bucket),
 		Key: aws.String(tokenFile),
 	}
 	output, err := s3svc.GetObject(input)
 	if err != nil {
+		metrics.AddCounter("cache_misses_total", "Cache misses per cached file kind.", 1, "file", "token")
 		return token, fmt.Errorf("%s - %w", tokenFile, errTokenFileDoesNotExist)
 	}
+	metrics.AddCounter("cache_hits_total", "Cache hits per cached file kind.", 1, "file", "token")
 	defer output.Body.Close()
 	tokenBytes, err := io.ReadAll(output.Body)
 
@@ -313,6 +433,18 @@ func readToken(s3svc *s3.S3, awsS3Bucket string) (token UserToken, err error) {
 		removeSilent(s3svc, awsS3Bucket, tokenFile)
 		return
 	}
+	tokenBytes, wasSealed, err := crypt.Open(tokenKey, tokenBytes)
+	if err != nil {
+		removeSilent(s3svc, awsS3Bucket, tokenFile)
+		return token, fmt.Errorf("can't decrypt token: %v - %w", err, errTokenFileDoesNotExist)
+	}
+	if tokenKey != nil && !wasSealed {
+		slog.Info("Encrypting plaintext cached token", "file", tokenFile)
+		err = writeToken(s3svc, awsS3Bucket, tokenKey, tokenBytes)
+		if err != nil {
+			return
+		}
+	}
 	err = json.Unmarshal(tokenBytes, &token)
 	if err != nil {
 		return
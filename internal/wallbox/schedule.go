@@ -0,0 +1,178 @@
+package wallbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+	"wallbox_nord_pool/internal/nordpool"
+)
+
+// ScheduleConfig writes the planned cheap windows to the on-device schedules,
+// so that the charger follows the plan by itself when the controller stops running.
+// The schedules repeat weekly, every run rewrites them from the plan: a schedule only has the day
+// of its window, passed windows and the other schedules of the charger are disabled.
+type ScheduleConfig struct {
+	// Windows is how many schedules are written, none when 0.
+	Windows int `yaml:"windows" validate:"min=0,max=20"`
+}
+
+func (config ScheduleConfig) Enabled() bool {
+	return config.Windows > 0
+}
+
+// Schedule is an on-device weekly schedule. Start and Stop are HHMM in UTC.
+type Schedule struct {
+	Id         int          `json:"id"`
+	ChargerId  int          `json:"chargerId"`
+	Enable     int          `json:"enable"`
+	MaxCurrent int          `json:"max_current"`
+	MaxEnergy  int          `json:"max_energy"`
+	Days       ScheduleDays `json:"days"`
+	Start      string       `json:"start"`
+	Stop       string       `json:"stop"`
+}
+
+type ScheduleDays struct {
+	Monday    bool `json:"monday"`
+	Tuesday   bool `json:"tuesday"`
+	Wednesday bool `json:"wednesday"`
+	Thursday  bool `json:"thursday"`
+	Friday    bool `json:"friday"`
+	Saturday  bool `json:"saturday"`
+	Sunday    bool `json:"sunday"`
+}
+
+type Schedules struct {
+	Schedules []Schedule `json:"schedules"`
+}
+
+// NewSchedules turns windows into count schedules, split at UTC midnight. Windows that don't fit are dropped,
+// schedules left over are disabled so that older windows are cleared.
+func NewSchedules(deviceId string, count int, maxCurrent int, windows []nordpool.Window) (schedules []Schedule) {
+	chargerId, _ := strconv.Atoi(deviceId)
+	for _, window := range windows {
+		start, end := window.Start.UTC(), window.End.UTC()
+		for start.Before(end) && len(schedules) < count {
+			midnight := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, time.UTC)
+			stop := end
+			if stop.After(midnight) {
+				stop = midnight
+			}
+			schedules = append(schedules, Schedule{
+				Id:         len(schedules),
+				ChargerId:  chargerId,
+				Enable:     1,
+				MaxCurrent: maxCurrent,
+				Days:       scheduleDays(start.Weekday()),
+				Start:      start.Format("1504"),
+				Stop:       stopTime(stop),
+			})
+			start = stop
+		}
+	}
+	for len(schedules) < count {
+		schedules = append(schedules, Schedule{Id: len(schedules), ChargerId: chargerId, Start: "0000", Stop: "0000"})
+	}
+	return
+}
+
+func stopTime(stop time.Time) string {
+	if stop.Hour() == 0 && stop.Minute() == 0 {
+		return "2400"
+	}
+	return stop.Format("1504")
+}
+
+func scheduleDays(weekday time.Weekday) (days ScheduleDays) {
+	flags := map[time.Weekday]*bool{time.Monday: &days.Monday, time.Tuesday: &days.Tuesday, time.Wednesday: &days.Wednesday,
+		time.Thursday: &days.Thursday, time.Friday: &days.Friday, time.Saturday: &days.Saturday, time.Sunday: &days.Sunday}
+	*flags[weekday] = true
+	return
+}
+
+func (wallbox Wallbox) GetSchedules() (schedules []Schedule, err error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.wall-box.com/chargers/%s/schedules", wallbox.deviceId), nil)
+	if err != nil {
+		return
+	}
+	wallbox.addHeaders(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = handleHttpError(resp)
+		return
+	}
+	schedulesBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	var response Schedules
+	err = json.Unmarshal(schedulesBytes, &response)
+	return response.Schedules, err
+}
+
+func (wallbox Wallbox) SetSchedules(schedules []Schedule) (err error) {
+	marshallBytes, err := json.Marshal(Schedules{schedules})
+	if err != nil {
+		return
+	}
+	body := bytes.NewReader(marshallBytes)
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://api.wall-box.com/chargers/%s/schedules", wallbox.deviceId), body)
+	if err != nil {
+		return
+	}
+	wallbox.addHeaders(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		err = handleHttpError(resp)
+		return
+	}
+	_, err = io.ReadAll(resp.Body)
+	return
+}
+
+// UpdateSchedules writes the schedules unless the charger already has them, the other schedules of the charger
+// are disabled. It tells whether they were written.
+func (wallbox Wallbox) UpdateSchedules(schedules []Schedule) (written bool, err error) {
+	current, err := wallbox.GetSchedules()
+	if err != nil {
+		return
+	}
+	schedules = disableStale(current, schedules)
+	slices.SortFunc(current, compareIds)
+	if reflect.DeepEqual(current, schedules) {
+		return false, nil
+	}
+	return true, wallbox.SetSchedules(schedules)
+}
+
+// disableStale returns the schedules with the current ones they don't have, disabled, in id order.
+// Those are left from earlier plans with more windows and would otherwise repeat every week.
+func disableStale(current []Schedule, schedules []Schedule) (all []Schedule) {
+	all = append(all, schedules...)
+	for _, schedule := range current {
+		if !slices.ContainsFunc(schedules, func(s Schedule) bool { return s.Id == schedule.Id }) {
+			schedule.Enable = 0
+			all = append(all, schedule)
+		}
+	}
+	slices.SortFunc(all, compareIds)
+	return
+}
+
+func compareIds(a Schedule, b Schedule) int {
+	return a.Id - b.Id
+}
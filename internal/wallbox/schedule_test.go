@@ -0,0 +1,69 @@
+package wallbox
+
+import (
+	"testing"
+	"time"
+	"wallbox_nord_pool/internal/nordpool"
+)
+
+func TestNewSchedules(t *testing.T) {
+	windows := []nordpool.Window{
+		{Start: time.Date(2023, 8, 1, 22, 0, 0, 0, time.UTC), End: time.Date(2023, 8, 2, 1, 30, 0, 0, time.UTC)},
+		{Start: time.Date(2023, 8, 2, 3, 0, 0, 0, time.UTC), End: time.Date(2023, 8, 2, 4, 0, 0, 0, time.UTC)},
+		{Start: time.Date(2023, 8, 2, 5, 0, 0, 0, time.UTC), End: time.Date(2023, 8, 2, 6, 0, 0, 0, time.UTC)},
+	}
+	schedules := NewSchedules("12345", 4, 16, windows)
+	want := []struct {
+		enable      int
+		start, stop string
+		tuesday     bool
+	}{
+		{enable: 1, start: "2200", stop: "2400", tuesday: true},
+		{enable: 1, start: "0000", stop: "0130"},
+		{enable: 1, start: "0300", stop: "0400"},
+		{enable: 1, start: "0500", stop: "0600"},
+	}
+	if len(schedules) != len(want) {
+		t.Fatalf("Got %d schedules, wanted %d", len(schedules), len(want))
+	}
+	for i, schedule := range schedules {
+		if schedule.Enable != want[i].enable || schedule.Start != want[i].start || schedule.Stop != want[i].stop ||
+			schedule.Days.Tuesday != want[i].tuesday || schedule.Days.Wednesday == want[i].tuesday {
+			t.Errorf("Got schedule %+v, wanted %+v", schedule, want[i])
+		}
+		if schedule.Id != i || schedule.ChargerId != 12345 || schedule.MaxCurrent != 16 {
+			t.Errorf("Got schedule %+v", schedule)
+		}
+	}
+	disabled := NewSchedules("12345", 2, 16, nil)
+	if len(disabled) != 2 || disabled[0].Enable != 0 || disabled[1].Enable != 0 {
+		t.Errorf("Got schedules %+v, wanted two disabled", disabled)
+	}
+}
+
+func TestDisableStale(t *testing.T) {
+	current := []Schedule{
+		{Id: 3, Enable: 1, Start: "0100", Stop: "0200"},
+		{Id: 0, Enable: 1, Start: "0300", Stop: "0400"},
+		{Id: 2, Enable: 0, Start: "0500", Stop: "0600"},
+	}
+	schedules := []Schedule{{Id: 0, Enable: 1, Start: "2200", Stop: "2400"}, {Id: 1, Start: "0000", Stop: "0000"}}
+	all := disableStale(current, schedules)
+	want := []struct {
+		id, enable  int
+		start, stop string
+	}{
+		{id: 0, enable: 1, start: "2200", stop: "2400"},
+		{id: 1, start: "0000", stop: "0000"},
+		{id: 2, start: "0500", stop: "0600"},
+		{id: 3, start: "0100", stop: "0200"},
+	}
+	if len(all) != len(want) {
+		t.Fatalf("Got %d schedules, wanted %d", len(all), len(want))
+	}
+	for i, schedule := range all {
+		if schedule.Id != want[i].id || schedule.Enable != want[i].enable || schedule.Start != want[i].start || schedule.Stop != want[i].stop {
+			t.Errorf("Got schedule %+v, wanted %+v", schedule, want[i])
+		}
+	}
+}
@@ -1,80 +1,588 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"gopkg.in/yaml.v3"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"wallbox_nord_pool/internal/charging"
+	"wallbox_nord_pool/internal/crypt"
+	"wallbox_nord_pool/internal/energy"
 	"wallbox_nord_pool/internal/flow"
+	"wallbox_nord_pool/internal/homeassistant"
+	"wallbox_nord_pool/internal/httpcharger"
+	"wallbox_nord_pool/internal/ical"
+	"wallbox_nord_pool/internal/logging"
+	"wallbox_nord_pool/internal/metrics"
+	"wallbox_nord_pool/internal/money"
 	"wallbox_nord_pool/internal/nordpool"
+	"wallbox_nord_pool/internal/notify"
+	"wallbox_nord_pool/internal/ocpp"
+	"wallbox_nord_pool/internal/secrets"
+	"wallbox_nord_pool/internal/site"
+	"wallbox_nord_pool/internal/validate"
 	"wallbox_nord_pool/internal/wallbox"
 )
 
 func main() {
+	logging.Setup()
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			slog.Error("Fatal error", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
 	lambda.Start(run)
 
-	//err := run()
+	//_, err := run(context.Background())
 	//if err != nil {
-	//	log.Fatalf("Fatal error: %v", err)
+	//	slog.Error("Fatal error", "error", err)
 	//}
 }
 
-func run() error {
-	awsRegion := os.Getenv("AWS_REGION")
+func run(ctx context.Context) (summary Summary, err error) {
+	return runWith(ctx, readConfig, daemonServices{})
+}
+
+// configLoader returns the config of a run.
+type configLoader func(svc *s3.S3, awsS3Bucket string) (err error, config Config)
+
+// daemonServices are served by the daemon between runs, they are nil in Lambda.
+type daemonServices struct {
+	centralSystem *ocpp.CentralSystem
+	homeAssistant *homeassistant.Bridge
+}
+
+func runWith(ctx context.Context, loadConfig configLoader, services daemonServices) (summary Summary, err error) {
+	start := time.Now()
+	logging.StartRun(ctx)
 	awsS3Bucket := os.Getenv("AWS_S3_BUCKET")
-	sess, err := session.NewSession(&aws.Config{Region: aws.String(awsRegion)})
+	dryRun, _ := strconv.ParseBool(os.Getenv("DRY_RUN"))
+	svc, err := newS3()
+	if err != nil {
+		return
+	}
+	err, config := loadConfig(svc, awsS3Bucket)
 	if err != nil {
-		return err
+		return
 	}
-	svc := s3.New(sess)
-	err, config := readConfig(svc, awsS3Bucket)
+	defer func() {
+		calls := metrics.TakeCalls()
+		if config.Metrics.Emf {
+			writeEMF(config.Metrics, start, summary, calls, err)
+		}
+	}()
+	notifier, err := notify.NewNotifier(config.Notify, svc, awsS3Bucket)
 	if err != nil {
-		return err
+		return
+	}
+	defer func() {
+		if closeErr := notifier.Close(); closeErr != nil {
+			slog.Warn("Failed to save notification state", "error", closeErr)
+		}
+	}()
+	tokenKey, err := newTokenKey(config.Wallbox.TokenEncryption)
+	if err != nil {
+		return
+	}
+	chargers := config.chargers()
+	if services.homeAssistant != nil {
+		if updateErr := services.homeAssistant.Update(config.HomeAssistant); updateErr != nil {
+			slog.Warn("Failed to connect to Home Assistant", "error", updateErr)
+		}
+		overrideMaxPrices(services.homeAssistant, chargers)
+	}
+	if services.centralSystem != nil {
+		services.centralSystem.Update(config.Ocpp)
+	}
+	env := driverEnv{config: config, svc: svc, awsS3Bucket: awsS3Bucket, tokenKey: tokenKey, notifier: notifier, centralSystem: services.centralSystem}
+	factories, openErrs := openDrivers(env, chargers)
+	summary.Chargers = make([]ChargerResult, len(chargers))
+	states := make([]flow.State, len(chargers))
+	wallboxes := make([]wallbox.Charger, len(chargers))
+	forEachCharger(summary.Chargers, func(i int) (err error) {
+		summary.Chargers[i].Name = chargers[i].Name
+		wallboxes[i], err = newCharger(factories, openErrs, chargers[i])
+		if err != nil {
+			return
+		}
+		states[i], err = planCharger(svc, awsS3Bucket, wallboxes[i], chargers[i].nordPoolConfig(config.NordPool), &summary.Chargers[i])
+		return
+	})
+	if config.Solar.Enabled() {
+		solarSurplus(config.Solar, chargers, wallboxes, states, summary.Chargers)
+	}
+	if services.homeAssistant != nil {
+		overrideModes(services.homeAssistant, chargers, states, summary.Chargers)
+	}
+	for i, charger := range chargers {
+		if summary.Chargers[i].Free && flow.WillCharge(states[i]) {
+			_, summary.Chargers[i].Current = flow.CurrentLimits(charger.MinCurrent, charger.MaxCurrent)
+			recordFreePrice(summary.Chargers[i])
+		}
+	}
+	allocations := make([]*site.Allocation, len(chargers))
+	if config.Site.Enabled() {
+		allocations = allocate(config, chargers, states, summary.Chargers)
+	}
+	executeChargers(wallboxes, states, allocations, dryRun, summary.Chargers)
+	if !dryRun {
+		followSessions(svc, awsS3Bucket, wallboxes, summary.Chargers)
+	}
+	if !dryRun && config.Wallbox.Schedule.Enabled() {
+		writeSchedules(svc, awsS3Bucket, config, chargers, wallboxes, summary.Chargers)
 	}
-	wb, err := wallbox.NewWallbox(config.Wallbox, svc, awsS3Bucket)
+	if config.Calendar.Enabled {
+		writeCalendar(svc, awsS3Bucket, config, chargers, summary.Chargers, dryRun)
+	}
+	if services.homeAssistant != nil && config.HomeAssistant.Enabled() {
+		publishHomeAssistant(services.homeAssistant, svc, awsS3Bucket, config, chargers, summary.Chargers)
+	}
+	summary.DryRun = dryRun
+	for _, result := range summary.Chargers {
+		notifyResult(notifier, config.Notify, result, dryRun)
+		recordMetrics(result)
+	}
+	if summary.Failed() == len(chargers) {
+		err = errors.New("all chargers failed")
+	}
+	return
+}
+
+// forEachCharger runs do concurrently for every charger that has not failed yet.
+// Errors and panics are recorded in the charger result so that one charger can't fail the others.
+func forEachCharger(results []ChargerResult, do func(i int) error) {
+	var wg sync.WaitGroup
+	for i := range results {
+		if results[i].Error != "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					results[i].Error = fmt.Sprintf("panic: %v", r)
+				}
+				if results[i].Error != "" {
+					slog.Error("Charger failed", "charger", results[i].Name, "error", results[i].Error)
+				}
+			}()
+			err := do(i)
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func planCharger(svc *s3.S3, awsS3Bucket string, wb wallbox.Charger, config nordpool.NordPoolConfig, result *ChargerResult) (flowState flow.State, err error) {
+	poolPrice, err := nordpool.GetPoolPrice(svc, awsS3Bucket, time.Now(), config)
 	if err != nil {
-		return err
+		return
 	}
-	price, err := nordpool.GetPrice(svc, awsS3Bucket, time.Now(), config.NordPool)
+	price, err := nordpool.CalculatePrice(config, time.Now(), poolPrice)
 	if err != nil {
-		return err
+		return
 	}
+	result.Price = price
 	desiredPrice, err := desiredPrice(svc, awsS3Bucket, config)
 	if err != nil {
-		return err
+		return
 	}
+	result.DesiredPrice = desiredPrice
+	deadline, err := nordpool.Deadline(config, time.Now())
+	if err != nil {
+		return
+	}
+	result.Deadline = deadline
 	status, err := wb.GetStatus()
 	if err != nil {
-		return err
+		return
+	}
+	result.Status = status
+	flowState = flow.NewFlowsState(price, desiredPrice, status)
+	if config.IsFree(poolPrice) {
+		flowState = flow.FreeState(status)
+		result.Free = true
+	}
+	slog.Info("Flow", "charger", result.Name, "state", flowState, "price", price, "desiredPrice", desiredPrice)
+	return
+}
+
+// allocate shares the site capacity between chargers that will draw current after their flow.
+func allocate(config Config, chargers []ChargerConfig, states []flow.State, results []ChargerResult) (allocations []*site.Allocation) {
+	allocations = make([]*site.Allocation, len(chargers))
+	var demands []site.Demand
+	var indexes []int
+	for i, charger := range chargers {
+		if results[i].Error != "" || !flow.WillCharge(states[i]) {
+			continue
+		}
+		maxCurrent := charger.MaxCurrent
+		if results[i].Surplus {
+			maxCurrent = results[i].Current
+		}
+		demands = append(demands, site.Demand{
+			Name:       charger.Name,
+			Priority:   charger.Priority,
+			Deadline:   results[i].Deadline,
+			MinCurrent: charger.MinCurrent,
+			MaxCurrent: maxCurrent,
+		})
+		indexes = append(indexes, i)
+	}
+	for j, allocation := range site.Allocate(config.Site.MaxCurrent, demands) {
+		allocation := allocation
+		allocations[indexes[j]] = &allocation
+		results[indexes[j]].Current = allocation.Current
+	}
+	return
+}
+
+// executeChargers performs the flow actions. The chargers that lower their draw go first so that a reallocation
+// never exceeds the site limit, even for a moment.
+func executeChargers(wallboxes []wallbox.Charger, states []flow.State, allocations []*site.Allocation, dryRun bool, results []ChargerResult) {
+	currents := make([]int, len(wallboxes))
+	if !dryRun {
+		forEachCharger(results, func(i int) error {
+			current, err := wallboxes[i].GetMaxCurrent()
+			if err != nil {
+				slog.Debug("Can't read max current, setting it", "charger", results[i].Name, "error", err)
+				return nil
+			}
+			currents[i] = current
+			return nil
+		})
+	}
+	for _, lowering := range []bool{true, false} {
+		forEachCharger(results, func(i int) (err error) {
+			if lowersDraw(results[i], currents[i], allocations[i]) != lowering {
+				return
+			}
+			return executeCharger(wallboxes[i], states[i], allocations[i], currents[i], dryRun, &results[i])
+		})
+	}
+}
+
+// lowersDraw tells if the charger draws less after the run, its current goes down or the site pauses it.
+func lowersDraw(result ChargerResult, current int, allocation *site.Allocation) bool {
+	return allocation != nil && allocation.Paused || result.Current > 0 && result.Current < current
+}
+
+// executeCharger performs the flow action, limited by the site allocation. The max current is only set when it
+// differs from current, the one the charger has or 0 when unknown. In dry run the action is only logged.
+func executeCharger(wb wallbox.Charger, flowState flow.State, allocation *site.Allocation, current int, dryRun bool, result *ChargerResult) (err error) {
+	result.Action = flow.NewAction(flowState)
+	if allocation != nil && allocation.Paused {
+		slog.Info("No site capacity left", "charger", result.Name)
+		result.Action = flow.ActionNone
+		if flowState.ChargerStatus == wallbox.Charging {
+			result.Action = flow.ActionPause
+		}
+	}
+	if dryRun {
+		slog.Info("Dry run, not performing action", "charger", result.Name, "action", result.Action, "current", result.Current)
+		return
+	}
+	// a stopped charger is paused at the current it has
+	if result.Current > 0 && result.Current != current && result.Mode != flow.ModeStop {
+		slog.Info("Setting max current", "charger", result.Name, "current", result.Current, "surplus", result.Surplus)
+		err = wb.SetMaxCurrent(result.Current)
+		if err != nil {
+			return
+		}
+	}
+	err = flow.DoAction(result.Action)(wb, result.Price, slog.With("charger", result.Name))
+	if err != nil {
+		return
+	}
+	metrics.AddCounter("actions_total", "Actions performed per charger and action.", 1, "charger", result.Name, "action", string(result.Action))
+	return
+}
+
+// solarSurplus gives the chargers that can soak up solar surplus its current and a good state whatever the price.
+// Without surplus, or when the grid power can't be read, chargers fall back to the price flow at their max current.
+func solarSurplus(config SolarConfig, chargers []ChargerConfig, wallboxes []wallbox.Charger, states []flow.State, results []ChargerResult) {
+	gridPower, err := readGridPower(config)
+	if err != nil {
+		slog.Warn("Can't read grid power, charging by price", "error", err)
+	} else {
+		slog.Info("Read grid power", "watts", gridPower)
+		metrics.SetGauge("grid_power_watts", "Grid power, negative when exporting.", gridPower)
+	}
+	surplusChargers := make([]flow.SurplusCharger, len(chargers))
+	for i, charger := range chargers {
+		surplusChargers[i] = flow.SurplusCharger{MinCurrent: charger.MinCurrent, MaxCurrent: charger.MaxCurrent}
+		if results[i].Error != "" || results[i].Free || !flow.WillCharge(flow.SurplusState(states[i])) {
+			continue
+		}
+		current, err := wallboxes[i].GetMaxCurrent()
+		if err != nil {
+			slog.Warn("Can't read max current, charging by price", "charger", charger.Name, "error", err)
+			continue
+		}
+		surplusChargers[i].State = states[i]
+		surplusChargers[i].Current = current
+		if meter, ok := wallboxes[i].(wallbox.Meter); ok {
+			power, err := meter.GetChargingPower()
+			if err != nil {
+				slog.Debug("Can't read charging power, ramping by the export", "charger", charger.Name, "error", err)
+				continue
+			}
+			surplusChargers[i].Power, surplusChargers[i].Metered = power, true
+		}
+	}
+	currents := make([]int, len(chargers))
+	if err == nil {
+		currents = config.Surplus.ShareSurplus(gridPower, surplusChargers)
+	}
+	for i, current := range currents {
+		_, maxCurrent := surplusChargers[i].Limits()
+		switch {
+		case current > 0:
+			states[i] = flow.SurplusState(states[i])
+			results[i].Surplus = true
+			results[i].Current = current
+		case flow.WillCharge(states[i]) && surplusChargers[i].Current > 0 && surplusChargers[i].Current < maxCurrent:
+			// the current reduced for an earlier surplus goes back up
+			results[i].Current = maxCurrent
+		}
+	}
+}
+
+func readGridPower(config SolarConfig) (gridPower float64, err error) {
+	source, err := energy.NewSource(config.Source)
+	if err != nil {
+		return
+	}
+	return source.GridPower()
+}
+
+// followSessions keeps the energy cost of charging sessions at the price of the current slot,
+// and sets it to the average of the session when it ends, so that the Wallbox app shows the real cost.
+func followSessions(svc *s3.S3, awsS3Bucket string, wallboxes []wallbox.Charger, results []ChargerResult) {
+	sessions, err := charging.Read(svc, awsS3Bucket)
+	if err != nil {
+		slog.Warn("Failed to read charging sessions", "error", err)
+		return
+	}
+	now := time.Now()
+	for i, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		costSet := result.Action == flow.ActionResume || result.Action == flow.ActionUnlock
+		paused := result.Action == flow.ActionPause
+		charging := costSet || (result.Status == wallbox.Charging && !paused)
+		update := sessions.Update(result.Name, charging, paused, result.Price, sessionPower(wallboxes[i], result.Name, charging), costSet, now)
+		if !update.SetCost {
+			continue
+		}
+		slog.Info("Setting energy cost", "charger", result.Name, "price", update.Cost, "sessionEnded", update.Ended)
+		err = wallboxes[i].SetEnergyCost(update.Cost)
+		if err != nil {
+			slog.Warn("Failed to set energy cost", "charger", result.Name, "error", err)
+		}
+	}
+	err = sessions.Write(svc, awsS3Bucket)
+	if err != nil {
+		slog.Warn("Failed to save charging sessions", "error", err)
+	}
+}
+
+// writeSchedules writes the cheap windows till the deadline to the on-device schedules of the Wallbox chargers,
+// so that they follow the plan when no further runs happen. Failures don't fail the charger.
+// sessionPower reads the power drawn by a charging charger, charging.UnknownPower without a meter.
+func sessionPower(wb wallbox.Charger, name string, isCharging bool) float64 {
+	meter, ok := wb.(wallbox.Meter)
+	if !isCharging || !ok {
+		return charging.UnknownPower
+	}
+	power, err := meter.GetChargingPower()
+	if err != nil {
+		slog.Debug("Can't read charging power, keeping the last one", "charger", name, "error", err)
+		return charging.UnknownPower
+	}
+	return power
+}
+
+func writeSchedules(svc *s3.S3, awsS3Bucket string, config Config, chargers []ChargerConfig, wallboxes []wallbox.Charger, results []ChargerResult) {
+	for i, charger := range chargers {
+		wb, ok := wallboxes[i].(wallbox.Wallbox)
+		if !ok || results[i].Error != "" {
+			continue
+		}
+		windows, err := nordpool.GetCheapWindows(svc, awsS3Bucket, time.Now(), charger.nordPoolConfig(config.NordPool), results[i].DesiredPrice)
+		if err != nil {
+			slog.Warn("Failed to plan schedules", "charger", charger.Name, "error", err)
+			continue
+		}
+		_, maxCurrent := flow.CurrentLimits(charger.MinCurrent, charger.MaxCurrent)
+		schedules := wallbox.NewSchedules(charger.DeviceId, config.Wallbox.Schedule.Windows, maxCurrent, windows)
+		written, err := wb.UpdateSchedules(schedules)
+		if err != nil {
+			slog.Warn("Failed to write schedules", "charger", charger.Name, "error", err)
+			continue
+		}
+		if written {
+			slog.Info("Wrote schedules", "charger", charger.Name, "windows", windows)
+		}
+	}
+}
+
+// recordFreePrice records that the charger charges at max current because the pool price is at or below the floor.
+func recordFreePrice(result ChargerResult) {
+	slog.Info("Pool price at or below the floor, charging at max current", "charger", result.Name, "price", result.Price, "current", result.Current)
+	metrics.AddCounter("price_free_total", "Runs charging at max current because the pool price was at or below the floor.", 1, "charger", result.Name)
+}
+
+func writeEMF(config metrics.Config, start time.Time, summary Summary, calls []metrics.Call, runErr error) {
+	emf := metrics.NewEMF(config, start)
+	actions := map[string]flow.Action{}
+	for _, result := range summary.Chargers {
+		if result.Error != "" {
+			continue
+		}
+		emf.Metric("Price", "None", result.Price)
+		emf.Metric("DesiredPrice", "None", result.DesiredPrice)
+		actions[result.Name] = result.Action
+	}
+	errorCount := summary.Failed()
+	if runErr != nil && errorCount == 0 {
+		errorCount = 1
 	}
-	var flowState = flow.NewFlowsState(price, desiredPrice, status)
-	log.Printf("Flow for state %s, price %f, desiredPrice %f", flowState, price, desiredPrice)
-	err = flow.DoFlow(flowState)(wb, price)
+	emf.Metric("Errors", "Count", float64(errorCount))
+	emf.Metric("RunDuration", "Milliseconds", float64(time.Since(start).Milliseconds()))
+	emf.Calls(calls)
+	emf.Property("Actions", actions)
+	emf.Property("DryRun", summary.DryRun)
+	err := emf.Write(os.Stdout)
 	if err != nil {
-		return err
+		slog.Warn("Failed to write EMF", "error", err)
 	}
-	return nil
 }
 
-func desiredPrice(svc *s3.S3, awsS3Bucket string, config Config) (desiredPrice float64, err error) {
-	minPrice, err := nordpool.GetMinPriceTill(svc, awsS3Bucket, time.Now(), config.NordPool)
+func recordMetrics(result ChargerResult) {
+	if result.Error != "" {
+		metrics.AddCounter("charger_errors_total", "Failed runs per charger.", 1, "charger", result.Name)
+		return
+	}
+	metrics.SetGauge("price", "Current price with VAT and transmission cost, per kWh in the nord-pool currency.", result.Price, "charger", result.Name)
+	metrics.SetGauge("desired_price", "Desired price, per kWh in the nord-pool currency.", result.DesiredPrice, "charger", result.Name)
+	for _, status := range wallbox.ChargerStatuses {
+		var value float64
+		if status == result.Status {
+			value = 1
+		}
+		metrics.SetGauge("charger_status", "Charger status, 1 for the current one.", value, "charger", result.Name, "status", string(status))
+	}
+}
+
+func notifyResult(notifier *notify.Notifier, config notify.Config, result ChargerResult, dryRun bool) {
+	if result.Error != "" {
+		notifier.Notify(notify.Message{Event: notify.EventRunError, Charger: result.Name, Text: fmt.Sprintf("Charger %s failed: %s", result.Name, result.Error)})
+		return
+	}
+	if !dryRun && result.Action != flow.ActionNone {
+		notifier.ActionChanged(result.Name, string(result.Action))
+	}
+	if result.Free && result.Current > 0 {
+		notifier.Notify(notify.Message{Event: notify.EventPriceFree, Charger: result.Name,
+			Text: fmt.Sprintf("Pool price is at or below the floor, charger %s charges at max current", result.Name)})
+	}
+	waiting := result.Status == wallbox.Paused || result.Status == wallbox.LockedWaiting || result.Status == wallbox.Scheduled
+	charging := result.Action == flow.ActionResume || result.Action == flow.ActionUnlock
+	if waiting && !charging && time.Until(result.Deadline) < config.DeadlineWarningOrDefault() {
+		notifier.Notify(notify.Message{Event: notify.EventDeadlineAtRisk, Charger: result.Name,
+			Text: fmt.Sprintf("Charger %s is not charging before deadline %s", result.Name, result.Deadline.Format("2006-01-02 15:04"))})
+	}
+}
+
+func desiredPrice(svc *s3.S3, awsS3Bucket string, config nordpool.NordPoolConfig) (desiredPrice float64, err error) {
+	minPrice, err := nordpool.GetMinPriceTill(svc, awsS3Bucket, time.Now(), config)
 	if err != nil {
 		return
 	}
-	if minPrice > config.NordPool.MaxPrice {
-		desiredPrice = config.NordPool.MaxPrice
+	maxPrice, err := config.Value(config.MaxPrice)
+	if err != nil {
+		return
+	}
+	if minPrice > maxPrice {
+		desiredPrice = maxPrice
 	} else {
 		desiredPrice = minPrice
 	}
 	return
 }
 
+func newSession() (*session.Session, error) {
+	awsRegion := os.Getenv("AWS_REGION")
+	return session.NewSession(&aws.Config{Region: aws.String(awsRegion)})
+}
+
+func newTokenKey(config crypt.Config) (tokenKey crypt.KeyProvider, err error) {
+	sess, err := newSession()
+	if err != nil {
+		return
+	}
+	return crypt.NewKeyProvider(config, sess)
+}
+
+func newS3() (svc *s3.S3, err error) {
+	sess, err := newSession()
+	if err != nil {
+		return
+	}
+	return s3.New(sess), nil
+}
+
 func readConfig(svc *s3.S3, awsS3Bucket string) (err error, config Config) {
+	configBytes, err := readConfigObject(svc, awsS3Bucket)
+	if err != nil {
+		return
+	}
+	node, err := configNode(configBytes)
+	if err != nil {
+		return
+	}
+	config, err = loadConfig(node)
+	return
+}
+
+// resolveSecrets replaces secret references, for example ssm:/wallbox/password, with their values.
+func resolveSecrets(config *Config) (err error) {
+	sess, err := newSession()
+	if err != nil {
+		return
+	}
+	return secrets.Default(sess).ResolveFields(config)
+}
+
+// readConfigBytes reads config.yaml from the bucket in AWS_S3_BUCKET.
+func readConfigBytes() (configBytes []byte, err error) {
+	svc, err := newS3()
+	if err != nil {
+		return
+	}
+	return readConfigObject(svc, os.Getenv("AWS_S3_BUCKET"))
+}
+
+func readConfigObject(svc *s3.S3, awsS3Bucket string) (configBytes []byte, err error) {
 	input := &s3.GetObjectInput{Bucket: aws.String(awsS3Bucket),
 		Key: aws.String("config.yaml"),
 	}
@@ -83,18 +591,193 @@ func readConfig(svc *s3.S3, awsS3Bucket string) (err error, config Config) {
 		return
 	}
 	defer output.Body.Close()
-	configBytes, err := io.ReadAll(output.Body)
+	return io.ReadAll(output.Body)
+}
+
+// readConfigFile reads a local config file with its host overlay.
+func readConfigFile(path string) (config Config, err error) {
+	node, err := readConfigLayers(path, hostOverlayPath(path))
+	if err != nil {
+		return
+	}
+	return loadConfig(node)
+}
+
+// loadConfig validates the config and resolves its secrets.
+func loadConfig(node *yaml.Node) (config Config, err error) {
+	config, problems := validateConfig(node)
+	if len(problems) > 0 {
+		return config, problems
+	}
+	err = resolveSecrets(&config)
 	if err != nil {
 		return
 	}
-	err = yaml.Unmarshal(configBytes, &config)
+	rates, err := money.NewRates(config.NordPool.Fx)
 	if err != nil {
 		return
 	}
+	config.NordPool = config.NordPool.WithRates(rates)
+	return
+}
+
+// validateConfig decodes the config strictly and reports every problem with its YAML path.
+func validateConfig(node *yaml.Node) (config Config, problems validate.Problems) {
+	lines := validate.Lines{}
+	problems = validate.DecodeNode(node, &config, lines)
+	if len(config.Chargers) == 0 && config.Wallbox.DeviceId == "" {
+		problems = append(problems, lines.Problem("wallbox.device-id", "is required when no chargers are listed"))
+	}
+	problems = append(problems, validateDrivers(config, lines)...)
+	problems = append(problems, validateCurrencies(config, lines)...)
+	problems = append(problems, validateZones(config, lines)...)
+	return
+}
+
+// validateZones reports known zones that the price source does not publish, unknown ones fail their oneof rule.
+func validateZones(config Config, lines validate.Lines) (problems validate.Problems) {
+	source := config.NordPool.SourceOrDefault()
+	if nordpool.Zones(source) == nil {
+		return
+	}
+	check := func(path string, zone string) {
+		if nordpool.HasZone(nordpool.SourceDataPortal, zone) && !nordpool.HasZone(source, zone) {
+			problems = append(problems, lines.Problem(path, fmt.Sprintf("%s publishes only %s", source, strings.Join(nordpool.Zones(source), ", "))))
+		}
+	}
+	check("nord-pool.zone", config.NordPool.Zone)
+	for i, charger := range config.Chargers {
+		if charger.Zone != "" {
+			check(fmt.Sprintf("chargers[%d].zone", i), charger.Zone)
+		}
+	}
+	return
+}
+
+// validateCurrencies reports prices that the fixed rates do not convert to the nord-pool currency.
+// Rates read from nord-pool.fx.url are only known at run time.
+func validateCurrencies(config Config, lines validate.Lines) (problems validate.Problems) {
+	nordPool := config.NordPool
+	if nordPool.Fx.Url != "" {
+		return
+	}
+	rates := money.FixedRates{Base: nordPool.Fx.Base, Rates: nordPool.Fx.Rates}
+	check := func(path string, price money.Price) {
+		if _, err := price.Convert(nordPool.CurrencyOrDefault(), rates); err != nil {
+			problems = append(problems, lines.Problem(path, err.Error()))
+		}
+	}
+	check("nord-pool.currency", money.New(0, money.EUR, money.MWh))
+	check("nord-pool.max-price", nordPool.MaxPrice)
+	check("nord-pool.transmission-cost.day", nordPool.TransmissionCost.Day)
+	check("nord-pool.transmission-cost.night", nordPool.TransmissionCost.Night)
+	if nordPool.PriceFloor != nil {
+		check("nord-pool.price-floor", *nordPool.PriceFloor)
+	}
+	for i, charger := range config.Chargers {
+		if charger.MaxPrice != nil {
+			check(fmt.Sprintf("chargers[%d].max-price", i), *charger.MaxPrice)
+		}
+	}
 	return
 }
 
 type Config struct {
 	NordPool nordpool.NordPoolConfig `yaml:"nord-pool"`
 	Wallbox  wallbox.Config          `yaml:"wallbox"`
+	Chargers []ChargerConfig         `yaml:"chargers"`
+	Site     site.Config             `yaml:"site"`
+	Notify   notify.Config           `yaml:"notify"`
+	Metrics  metrics.Config          `yaml:"metrics"`
+	Solar    SolarConfig             `yaml:"solar"`
+	Ocpp     ocpp.Config             `yaml:"ocpp"`
+	Calendar ical.Config             `yaml:"calendar"`
+	// HomeAssistant is only used by the daemon.
+	HomeAssistant homeassistant.Config `yaml:"home-assistant"`
+}
+
+// SolarConfig charges from the solar surplus read from Source, the price flow is the fallback.
+type SolarConfig struct {
+	Source  energy.Config      `yaml:"source"`
+	Surplus flow.SurplusConfig `yaml:"surplus"`
+}
+
+func (config SolarConfig) Enabled() bool {
+	return config.Source.Enabled()
+}
+
+// ChargerConfig describes one charger. Empty fields fall back to the nord-pool defaults.
+// The device id of an OCPP charger is its charge point id, optionally followed by :connector.
+type ChargerConfig struct {
+	Name                string       `yaml:"name"`
+	Type                string       `yaml:"type" validate:"oneof=wallbox ocpp http"`
+	DeviceId            string       `yaml:"device-id" validate:"required"`
+	Zone                string       `yaml:"zone" validate:"oneof=lt lv ee fi se1 se2 se3 se4 no1 no2 no3 no4 no5 dk1 dk2"`
+	MaxPrice            *money.Price `yaml:"max-price" validate:"min=0"`
+	ChargeTillHourDay   *int         `yaml:"charge-till-hour-day" validate:"min=0,max=23"`
+	ChargeTillHourNight *int         `yaml:"charge-till-hour-night" validate:"min=0,max=23"`
+	Priority            int          `yaml:"priority"`
+	MinCurrent          int          `yaml:"min-current" validate:"min=0"`
+	MaxCurrent          int          `yaml:"max-current" validate:"min=0"`
+	// Http configures the requests of an http charger.
+	Http httpcharger.Config `yaml:"http"`
+}
+
+type ChargerResult struct {
+	Name         string                `json:"name"`
+	Status       wallbox.ChargerStatus `json:"status,omitempty"`
+	Price        float64               `json:"price"`
+	DesiredPrice float64               `json:"desiredPrice"`
+	Deadline     time.Time             `json:"deadline"`
+	Current      int                   `json:"current,omitempty"`
+	Surplus      bool                  `json:"surplus,omitempty"`
+	Free         bool                  `json:"free,omitempty"`
+	Mode         flow.Mode             `json:"mode,omitempty"`
+	Action       flow.Action           `json:"action,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+type Summary struct {
+	DryRun   bool            `json:"dryRun,omitempty"`
+	Chargers []ChargerResult `json:"chargers"`
+}
+
+func (summary Summary) Failed() (failed int) {
+	for _, result := range summary.Chargers {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	return
+}
+
+// chargers returns the configured chargers, or the single wallbox device when no list is given.
+func (config Config) chargers() (chargers []ChargerConfig) {
+	if len(config.Chargers) == 0 {
+		return []ChargerConfig{{Name: config.Wallbox.DeviceId, DeviceId: config.Wallbox.DeviceId}}
+	}
+	for _, charger := range config.Chargers {
+		if charger.Name == "" {
+			charger.Name = charger.DeviceId
+		}
+		chargers = append(chargers, charger)
+	}
+	return
+}
+
+func (charger ChargerConfig) nordPoolConfig(base nordpool.NordPoolConfig) (config nordpool.NordPoolConfig) {
+	config = base
+	if charger.Zone != "" {
+		config.Zone = charger.Zone
+	}
+	if charger.MaxPrice != nil {
+		config.MaxPrice = *charger.MaxPrice
+	}
+	if charger.ChargeTillHourDay != nil {
+		config.ChargeTillHourDay = *charger.ChargeTillHourDay
+	}
+	if charger.ChargeTillHourNight != nil {
+		config.ChargeTillHourNight = *charger.ChargeTillHourNight
+	}
+	return
 }
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"wallbox_nord_pool/internal/energy"
+	"wallbox_nord_pool/internal/flow"
+	"wallbox_nord_pool/internal/nordpool"
+	"wallbox_nord_pool/internal/site"
+	"wallbox_nord_pool/internal/validate"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+var errFake = errors.New("fake error")
+
+// fakeCharger records the calls made to it and fails the ones in errs.
+type fakeCharger struct {
+	status     wallbox.ChargerStatus
+	maxCurrent int
+	errs       map[string]error
+	calls      []string
+}
+
+func (charger *fakeCharger) call(name string) error {
+	charger.calls = append(charger.calls, name)
+	return charger.errs[name]
+}
+
+func (charger *fakeCharger) GetStatus() (wallbox.ChargerStatus, error) {
+	return charger.status, charger.call("GetStatus")
+}
+
+func (charger *fakeCharger) GetMaxCurrent() (int, error) {
+	return charger.maxCurrent, charger.call("GetMaxCurrent")
+}
+
+func (charger *fakeCharger) SetMaxCurrent(current int) error {
+	charger.maxCurrent = current
+	return charger.call("SetMaxCurrent")
+}
+
+func (charger *fakeCharger) SetEnergyCost(_ float64) error {
+	return charger.call("SetEnergyCost")
+}
+
+func (charger *fakeCharger) Unlock() error {
+	return charger.call("Unlock")
+}
+
+func (charger *fakeCharger) PauseCharging() error {
+	return charger.call("PauseCharging")
+}
+
+func (charger *fakeCharger) ResumeCharging() error {
+	return charger.call("ResumeCharging")
+}
+
+func TestForEachCharger(t *testing.T) {
+	tests := []struct {
+		name       string
+		do         func() error
+		openErr    string
+		wantErr    string
+		wantCalled bool
+	}{
+		{name: "Ok", do: func() error { return nil }, wantCalled: true},
+		{name: "Error", do: func() error { return errFake }, wantErr: errFake.Error(), wantCalled: true},
+		{name: "Panic", do: func() error { panic("boom") }, wantErr: "panic: boom", wantCalled: true},
+		{name: "Failed before", do: func() error { return nil }, openErr: "login failed", wantErr: "login failed"},
+	}
+	results := make([]ChargerResult, len(tests))
+	called := make([]bool, len(tests))
+	for i, tt := range tests {
+		results[i] = ChargerResult{Name: tt.name, Error: tt.openErr}
+	}
+	forEachCharger(results, func(i int) error {
+		called[i] = true
+		return tests[i].do()
+	})
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if results[i].Error != tt.wantErr || called[i] != tt.wantCalled {
+				t.Errorf("Got error %q called %t, wanted %q called %t", results[i].Error, called[i], tt.wantErr, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestOpenDrivers(t *testing.T) {
+	defaultDrivers := drivers
+	drivers = map[string]driver{
+		"good": {open: func(_ driverEnv) (chargerFactory, error) {
+			return func(_ ChargerConfig) (wallbox.Charger, error) { return &fakeCharger{status: wallbox.Ready}, nil }, nil
+		}},
+		"broken": {open: func(_ driverEnv) (chargerFactory, error) { return nil, errFake }},
+	}
+	defer func() { drivers = defaultDrivers }()
+	tests := []struct {
+		charger ChargerConfig
+		wantErr error
+	}{
+		{charger: ChargerConfig{Name: "a", Type: "good"}},
+		{charger: ChargerConfig{Name: "b", Type: "broken"}, wantErr: errFake},
+		{charger: ChargerConfig{Name: "c", Type: "Good"}},
+		{charger: ChargerConfig{Name: "d", Type: "missing"}, wantErr: errUnknownChargerType},
+	}
+	chargers := make([]ChargerConfig, len(tests))
+	for i, tt := range tests {
+		chargers[i] = tt.charger
+	}
+	factories, openErrs := openDrivers(driverEnv{}, chargers)
+	results := make([]ChargerResult, len(chargers))
+	errs := make([]error, len(chargers))
+	forEachCharger(results, func(i int) (err error) {
+		results[i].Name = chargers[i].Name
+		var wb wallbox.Charger
+		wb, errs[i] = newCharger(factories, openErrs, chargers[i])
+		if errs[i] != nil {
+			return errs[i]
+		}
+		results[i].Status, err = wb.GetStatus()
+		return
+	})
+	for i, tt := range tests {
+		t.Run(tt.charger.Name, func(t *testing.T) {
+			if !errors.Is(errs[i], tt.wantErr) {
+				t.Errorf("Got Error %v, wanted %v", errs[i], tt.wantErr)
+			}
+			if tt.wantErr == nil && (results[i].Error != "" || results[i].Status != wallbox.Ready) {
+				t.Errorf("Got result %+v, wanted the other drivers to carry on", results[i])
+			}
+			if tt.wantErr != nil && results[i].Error == "" {
+				t.Errorf("Got no error in result %+v", results[i])
+			}
+		})
+	}
+}
+
+func TestSelectChargers(t *testing.T) {
+	chargers := []ChargerConfig{{Name: "garage", DeviceId: "123"}, {Name: "carport", DeviceId: "456"}}
+	tests := []struct {
+		name      string
+		selection string
+		want      []string
+		wantErr   error
+	}{
+		{name: "All", selection: "", want: []string{"garage", "carport"}},
+		{name: "By name", selection: "carport", want: []string{"carport"}},
+		{name: "By device id", selection: "123", want: []string{"garage"}},
+		{name: "Unknown", selection: "shed", wantErr: errUnknownCharger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := selectChargers(chargers, tt.selection)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Got Error %v, wanted %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, charger := range selected {
+				got = append(got, charger.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Got chargers %v, wanted %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateZones(t *testing.T) {
+	tests := []struct {
+		name         string
+		source       string
+		zone         string
+		wantProblems int
+	}{
+		{name: "Elering zone", zone: "lt"},
+		{name: "Data Portal zone on Elering", zone: "se3", wantProblems: 1},
+		{name: "Data Portal zone", source: "data-portal", zone: "se3"},
+		{name: "Mixed case source", source: "Data-Portal", zone: "se3"},
+		{name: "Mixed case Elering", source: "Elering", zone: "se3", wantProblems: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{NordPool: nordpool.NordPoolConfig{Source: tt.source, Zone: tt.zone}}
+			problems := validateZones(config, validate.Lines{})
+			if len(problems) != tt.wantProblems {
+				t.Errorf("Got problems %v, wanted %d", problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestPerformAction(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     flow.Action
+		errs       map[string]error
+		wantCalls  []string
+		wantErr    bool
+		wantResult string
+	}{
+		{name: "Pause", action: flow.ActionPause, wantCalls: []string{"PauseCharging", "GetStatus"}},
+		{name: "Resume", action: flow.ActionResume, wantCalls: []string{"ResumeCharging", "GetStatus"}},
+		{name: "Unlock", action: flow.ActionUnlock, wantCalls: []string{"Unlock", "GetStatus"}},
+		{name: "Action fails", action: flow.ActionPause, errs: map[string]error{"PauseCharging": errFake}, wantCalls: []string{"PauseCharging"}, wantErr: true},
+		{name: "Status fails", action: flow.ActionResume, errs: map[string]error{"GetStatus": errFake}, wantCalls: []string{"ResumeCharging", "GetStatus"}, wantResult: errFake.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			charger := &fakeCharger{status: wallbox.Charging, errs: tt.errs}
+			result, err := performAction(charger, "garage", tt.action)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got Error %v, wanted error %t", err, tt.wantErr)
+			}
+			if !slices.Equal(charger.calls, tt.wantCalls) {
+				t.Errorf("Got calls %v, wanted %v", charger.calls, tt.wantCalls)
+			}
+			if !tt.wantErr && (result.Name != "garage" || result.Action != tt.action || result.Error != tt.wantResult) {
+				t.Errorf("Got result %+v", result)
+			}
+		})
+	}
+}
+
+func TestExecuteCharger(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     flow.State
+		result    ChargerResult
+		current   int
+		dryRun    bool
+		wantCalls []string
+	}{
+		{name: "Resume", state: flow.PausedPriceGood, wantCalls: []string{"SetEnergyCost", "ResumeCharging"}},
+		{name: "Surplus current", state: flow.ChargingPriceGood, result: ChargerResult{Current: 10, Surplus: true}, wantCalls: []string{"SetMaxCurrent"}},
+		{name: "Stop keeps the current", state: flow.OverrideState(flow.ChargingPriceGood, flow.ModeStop), result: ChargerResult{Current: 10, Mode: flow.ModeStop},
+			wantCalls: []string{"PauseCharging"}},
+		{name: "Unchanged current", state: flow.ChargingPriceGood, result: ChargerResult{Current: 10, Surplus: true}, current: 10},
+		{name: "Dry run", state: flow.PausedPriceGood, result: ChargerResult{Current: 10}, dryRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			charger := &fakeCharger{}
+			result := tt.result
+			result.Name = "garage"
+			err := executeCharger(charger, tt.state, nil, tt.current, tt.dryRun, &result)
+			if err != nil {
+				t.Fatalf("Got Error %s", err)
+			}
+			if !slices.Equal(charger.calls, tt.wantCalls) {
+				t.Errorf("Got calls %v, wanted %v", charger.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// orderedCharger records the order in which the max currents of several chargers are set.
+type orderedCharger struct {
+	*fakeCharger
+	name  string
+	mutex *sync.Mutex
+	order *[]string
+}
+
+func (charger orderedCharger) SetMaxCurrent(current int) error {
+	charger.mutex.Lock()
+	defer charger.mutex.Unlock()
+	*charger.order = append(*charger.order, charger.name)
+	return charger.fakeCharger.SetMaxCurrent(current)
+}
+
+func TestExecuteChargers(t *testing.T) {
+	var mutex sync.Mutex
+	var order []string
+	chargers := []struct {
+		name    string
+		current int
+		result  ChargerResult
+	}{
+		{name: "up", current: 6, result: ChargerResult{Current: 16}},
+		{name: "same", current: 10, result: ChargerResult{Current: 10}},
+		{name: "down", current: 16, result: ChargerResult{Current: 6}},
+	}
+	wallboxes := make([]wallbox.Charger, len(chargers))
+	results := make([]ChargerResult, len(chargers))
+	states := make([]flow.State, len(chargers))
+	for i, charger := range chargers {
+		wallboxes[i] = orderedCharger{fakeCharger: &fakeCharger{maxCurrent: charger.current}, name: charger.name, mutex: &mutex, order: &order}
+		results[i] = charger.result
+		results[i].Name = charger.name
+		states[i] = flow.ChargingPriceGood
+	}
+	executeChargers(wallboxes, states, make([]*site.Allocation, len(chargers)), false, results)
+	if want := []string{"down", "up"}; !slices.Equal(order, want) {
+		t.Errorf("Got currents set %v, wanted %v", order, want)
+	}
+	for i, charger := range chargers {
+		if current := wallboxes[i].(orderedCharger).maxCurrent; current != charger.result.Current || results[i].Error != "" {
+			t.Errorf("Got current %d error %q for %s, wanted %d", current, results[i].Error, charger.name, charger.result.Current)
+		}
+	}
+}
+
+func TestSolarSurplusWithoutGridPower(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	config := SolarConfig{Source: energy.Config{Http: energy.HttpConfig{Url: server.URL}}}
+	tests := []struct {
+		name        string
+		state       flow.State
+		current     int
+		wantCurrent int
+	}{
+		{name: "Reduced current restored", state: flow.ChargingPriceGood, current: 8, wantCurrent: 16},
+		{name: "Max current kept", state: flow.ChargingPriceGood, current: 16},
+		{name: "Price too big", state: flow.State{ChargerStatus: wallbox.Charging, PriceStatus: nordpool.PriceTooBig}, current: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chargers := []ChargerConfig{{Name: "garage", MaxCurrent: 16}}
+			states := []flow.State{tt.state}
+			results := []ChargerResult{{Name: "garage"}}
+			solarSurplus(config, chargers, []wallbox.Charger{&fakeCharger{maxCurrent: tt.current}}, states, results)
+			if results[0].Current != tt.wantCurrent || results[0].Surplus || states[0] != tt.state {
+				t.Errorf("Got result %+v state %v, wanted current %d by price", results[0], states[0], tt.wantCurrent)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"wallbox_nord_pool/internal/flow"
+	"wallbox_nord_pool/internal/logging"
+	"wallbox_nord_pool/internal/nordpool"
+	"wallbox_nord_pool/internal/notify"
+	"wallbox_nord_pool/internal/wallbox"
+)
+
+const timeFormat = "2006-01-02 15:04"
+
+var (
+	errUnknownCharger = errors.New("unknown charger")
+	errPickCharger    = errors.New("more than one charger, pick one with -charger")
+)
+
+// operatorFlags are the flags every operator command takes.
+type operatorFlags struct {
+	flags      *flag.FlagSet
+	configPath *string
+	charger    *string
+	jsonOutput *bool
+	verbose    *bool
+}
+
+func newOperatorFlags(name string) operatorFlags {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	return operatorFlags{
+		flags:      flags,
+		configPath: flags.String("config", "", "local config YAML file, config.yaml from the bucket when empty"),
+		charger:    flags.String("charger", "", "name or device id of the charger, all chargers when empty"),
+		jsonOutput: flags.Bool("json", false, "print JSON"),
+		verbose:    flags.Bool("verbose", false, "log what the command does"),
+	}
+}
+
+// operatorEnv is what operator commands work with.
+type operatorEnv struct {
+	svc         *s3.S3
+	awsS3Bucket string
+	config      Config
+	chargers    []ChargerConfig
+}
+
+// parse parses the flags and loads the config with the selected chargers. Logs go to stderr, warnings only
+// unless verbose, so that the output stays readable.
+func (flags operatorFlags) parse(args []string) (env operatorEnv, err error) {
+	_ = flags.flags.Parse(args)
+	if !*flags.verbose {
+		logging.SetupWith(os.Stderr, slog.LevelWarn, false)
+	}
+	env.awsS3Bucket = os.Getenv("AWS_S3_BUCKET")
+	env.svc, err = newS3()
+	if err != nil {
+		return
+	}
+	if *flags.configPath != "" {
+		env.config, err = readConfigFile(*flags.configPath)
+	} else {
+		err, env.config = readConfig(env.svc, env.awsS3Bucket)
+	}
+	if err != nil {
+		return
+	}
+	env.chargers, err = selectChargers(env.config.chargers(), *flags.charger)
+	return
+}
+
+// selectChargers returns the charger with the name or device id, all chargers when it is empty.
+func selectChargers(chargers []ChargerConfig, name string) (selected []ChargerConfig, err error) {
+	if name == "" {
+		return chargers, nil
+	}
+	for _, charger := range chargers {
+		if charger.Name == name || charger.DeviceId == name {
+			return []ChargerConfig{charger}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s : %w", name, errUnknownCharger)
+}
+
+// openChargers opens the drivers of the chargers, errs has the error of each charger that failed to open.
+// OCPP chargers are only reachable through the daemon.
+func (env operatorEnv) openChargers() (wallboxes []wallbox.Charger, errs []error, err error) {
+	notifier, err := notify.NewNotifier(env.config.Notify, env.svc, env.awsS3Bucket)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := notifier.Close(); closeErr != nil {
+			slog.Warn("Failed to save notification state", "error", closeErr)
+		}
+	}()
+	tokenKey, err := newTokenKey(env.config.Wallbox.TokenEncryption)
+	if err != nil {
+		return
+	}
+	drivers := driverEnv{config: env.config, svc: env.svc, awsS3Bucket: env.awsS3Bucket, tokenKey: tokenKey, notifier: notifier}
+	factories, openErrs := openDrivers(drivers, env.chargers)
+	wallboxes = make([]wallbox.Charger, len(env.chargers))
+	errs = make([]error, len(env.chargers))
+	for i, charger := range env.chargers {
+		wallboxes[i], errs[i] = newCharger(factories, openErrs, charger)
+	}
+	return
+}
+
+// printOutput writes value as JSON, or as a table through the function.
+func printOutput(jsonOutput bool, value any, table func(w io.Writer)) error {
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+type chargerPrices struct {
+	Name     string               `json:"name"`
+	Zone     string               `json:"zone"`
+	Currency string               `json:"currency"`
+	Slots    []nordpool.Breakdown `json:"slots"`
+}
+
+// pricesCommand prints today's and, once published, tomorrow's prices of each charger with the tariff breakdown.
+func pricesCommand(args []string) (err error) {
+	flags := newOperatorFlags("prices")
+	env, err := flags.parse(args)
+	if err != nil {
+		return
+	}
+	var curves []chargerPrices
+	for _, charger := range env.chargers {
+		config := charger.nordPoolConfig(env.config.NordPool)
+		curve := chargerPrices{Name: charger.Name, Zone: config.Zone, Currency: string(config.CurrencyOrDefault())}
+		for day := 0; day < 2; day++ {
+			prices, err := nordpool.GetDayPrices(env.svc, env.awsS3Bucket, time.Now().AddDate(0, 0, day), config)
+			if err != nil && day == 0 {
+				return err
+			}
+			if err != nil || len(prices) == 0 {
+				slog.Warn("No prices for tomorrow yet", "charger", charger.Name, "error", err)
+				continue
+			}
+			for _, price := range prices {
+				breakdown, err := nordpool.PriceBreakdown(config, price)
+				if err != nil {
+					return err
+				}
+				curve.Slots = append(curve.Slots, breakdown)
+			}
+		}
+		curves = append(curves, curve)
+	}
+	return printOutput(*flags.jsonOutput, curves, func(w io.Writer) {
+		for _, curve := range curves {
+			fmt.Fprintf(w, "%s, zone %s, per kWh in %s\n", curve.Name, curve.Zone, curve.Currency)
+			fmt.Fprintln(w, "START\tEND\tPOOL EUR/MWh\tENERGY\tVAT\tTRANSMISSION\tPRICE\tFREE")
+			for _, slot := range curve.Slots {
+				fmt.Fprintf(w, "%s\t%s\t%.2f\t%.4f\t%.4f\t%.4f\t%.4f\t%s\n", slot.Start.Format(timeFormat), slot.End.Format("15:04"),
+					slot.PoolPrice, slot.Energy, slot.Vat, slot.Transmission, slot.Price, yes(slot.Free))
+			}
+			fmt.Fprintln(w)
+		}
+	})
+}
+
+type chargerStatus struct {
+	Name       string                `json:"name"`
+	Type       string                `json:"type"`
+	Status     wallbox.ChargerStatus `json:"status,omitempty"`
+	MaxCurrent int                   `json:"maxCurrent,omitempty"`
+	Error      string                `json:"error,omitempty"`
+}
+
+// statusCommand prints the state of the chargers.
+func statusCommand(args []string) (err error) {
+	flags := newOperatorFlags("status")
+	env, err := flags.parse(args)
+	if err != nil {
+		return
+	}
+	wallboxes, errs, err := env.openChargers()
+	if err != nil {
+		return
+	}
+	statuses := make([]chargerStatus, len(env.chargers))
+	for i, charger := range env.chargers {
+		statuses[i] = chargerStatus{Name: charger.Name, Type: charger.chargerType()}
+		err := errs[i]
+		if err == nil {
+			statuses[i].Status, err = wallboxes[i].GetStatus()
+		}
+		if err == nil {
+			statuses[i].MaxCurrent, err = wallboxes[i].GetMaxCurrent()
+		}
+		if err != nil {
+			statuses[i].Error = err.Error()
+		}
+	}
+	return printOutput(*flags.jsonOutput, statuses, func(w io.Writer) {
+		fmt.Fprintln(w, "CHARGER\tTYPE\tSTATUS\tMAX CURRENT\tERROR")
+		for _, status := range statuses {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", status.Name, status.Type, status.Status, status.MaxCurrent, status.Error)
+		}
+	})
+}
+
+type actionResult struct {
+	Name   string                `json:"name"`
+	Action flow.Action           `json:"action"`
+	Status wallbox.ChargerStatus `json:"status,omitempty"`
+	Error  string                `json:"error,omitempty"`
+}
+
+// actionCommand pauses, resumes or unlocks one charger, whatever the price.
+func actionCommand(name string, action flow.Action, args []string) (err error) {
+	flags := newOperatorFlags(name)
+	env, err := flags.parse(args)
+	if err != nil {
+		return
+	}
+	if len(env.chargers) != 1 {
+		return errPickCharger
+	}
+	wallboxes, errs, err := env.openChargers()
+	if err != nil {
+		return
+	}
+	if errs[0] != nil {
+		return errs[0]
+	}
+	result, err := performAction(wallboxes[0], env.chargers[0].Name, action)
+	if err != nil {
+		return
+	}
+	return printOutput(*flags.jsonOutput, result, func(w io.Writer) {
+		fmt.Fprintf(w, "%s: %s, status %s\n", result.Name, result.Action, result.Status)
+	})
+}
+
+// performAction performs the action on the charger and reads the status it has then.
+func performAction(wb wallbox.Charger, name string, action flow.Action) (result actionResult, err error) {
+	switch action {
+	case flow.ActionPause:
+		err = wb.PauseCharging()
+	case flow.ActionResume:
+		err = wb.ResumeCharging()
+	case flow.ActionUnlock:
+		err = wb.Unlock()
+	}
+	if err != nil {
+		return
+	}
+	result = actionResult{Name: name, Action: action}
+	status, statusErr := wb.GetStatus()
+	if statusErr != nil {
+		result.Error = statusErr.Error()
+	}
+	result.Status = status
+	return
+}
+
+type chargerPlan struct {
+	Name         string            `json:"name"`
+	Deadline     time.Time         `json:"deadline"`
+	DesiredPrice float64           `json:"desiredPrice"`
+	Currency     string            `json:"currency"`
+	Windows      []nordpool.Window `json:"windows"`
+}
+
+// planCommand prints the cheap windows the chargers will charge in before their next deadline.
+func planCommand(args []string) (err error) {
+	flags := newOperatorFlags("plan")
+	env, err := flags.parse(args)
+	if err != nil {
+		return
+	}
+	plans := make([]chargerPlan, len(env.chargers))
+	for i, charger := range env.chargers {
+		config := charger.nordPoolConfig(env.config.NordPool)
+		plans[i] = chargerPlan{Name: charger.Name, Currency: string(config.CurrencyOrDefault())}
+		plans[i].Deadline, err = nordpool.Deadline(config, time.Now())
+		if err != nil {
+			return
+		}
+		plans[i].DesiredPrice, err = desiredPrice(env.svc, env.awsS3Bucket, config)
+		if err != nil {
+			return
+		}
+		plans[i].Windows, err = nordpool.GetCheapWindows(env.svc, env.awsS3Bucket, time.Now(), config, plans[i].DesiredPrice)
+		if err != nil {
+			return
+		}
+	}
+	return printOutput(*flags.jsonOutput, plans, func(w io.Writer) {
+		for _, plan := range plans {
+			fmt.Fprintf(w, "%s, deadline %s, desired price %.4f %s/kWh\n", plan.Name, plan.Deadline.Format(timeFormat), plan.DesiredPrice, plan.Currency)
+			fmt.Fprintln(w, "START\tEND\tAVG PRICE")
+			for _, window := range plan.Windows {
+				fmt.Fprintf(w, "%s\t%s\t%.4f\n", window.Start.Format(timeFormat), window.End.Format(timeFormat), window.Price)
+			}
+			if len(plan.Windows) == 0 {
+				fmt.Fprintln(w, "no cheap slots before the deadline")
+			}
+			fmt.Fprintln(w)
+		}
+	})
+}
+
+// runControllerCommand runs the controller, once or every interval, and prints the summary of each run.
+func runControllerCommand(args []string) (err error) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	once := flags.Bool("once", false, "run once and exit")
+	interval := flags.Duration("interval", 15*time.Minute, "how often to run the controller without -once")
+	configPath := flags.String("config", "", "local config YAML file, config.yaml from the bucket when empty")
+	dryRun := flags.Bool("dry-run", false, "plan without changing the chargers, like DRY_RUN=true")
+	jsonOutput := flags.Bool("json", false, "print the summary as JSON")
+	_ = flags.Parse(args)
+	if *dryRun {
+		_ = os.Setenv("DRY_RUN", "true")
+	}
+	loadConfig := configLoader(readConfig)
+	if *configPath != "" {
+		loadConfig = func(_ *s3.S3, _ string) (err error, config Config) {
+			config, err = readConfigFile(*configPath)
+			return
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for {
+		summary, err := runWith(ctx, loadConfig, daemonServices{})
+		if err != nil {
+			if *once {
+				return err
+			}
+			slog.Error("Run failed", "error", err)
+		} else if err = printSummary(*jsonOutput, summary); err != nil {
+			return err
+		}
+		if *once {
+			if failed := summary.Failed(); failed > 0 {
+				return fmt.Errorf("%d of %d chargers failed", failed, len(summary.Chargers))
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(time.Now().Truncate(*interval).Add(*interval))):
+		}
+	}
+}
+
+func printSummary(jsonOutput bool, summary Summary) error {
+	return printOutput(jsonOutput, summary, func(w io.Writer) {
+		if summary.DryRun {
+			fmt.Fprintln(w, "dry run, chargers not changed")
+		}
+		fmt.Fprintln(w, "CHARGER\tSTATUS\tPRICE\tDESIRED\tDEADLINE\tCURRENT\tMODE\tACTION\tERROR")
+		for _, result := range summary.Chargers {
+			var deadline string
+			if !result.Deadline.IsZero() {
+				deadline = result.Deadline.Format(timeFormat)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.4f\t%.4f\t%s\t%d\t%s\t%s\t%s\n", result.Name, result.Status, result.Price, result.DesiredPrice,
+				deadline, result.Current, result.Mode, result.Action, strings.ReplaceAll(result.Error, "\n", " "))
+		}
+	})
+}
+
+func yes(value bool) string {
+	if value {
+		return "yes"
+	}
+	return ""
+}